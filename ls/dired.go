@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// diredIndent starts every line of --dired output except the trailing
+// offset lists.
+const diredIndent = "  "
+
+var (
+	// byte offsets of the start and end of each listed name and of each
+	// directory name in a header
+	diredNames   []int
+	diredSubdirs []int
+)
+
+// printHeader writes the "dir:" line above a directory's listing.
+func printHeader(dirname string) {
+	if !*diredFlag {
+		fmt.Fprintf(stdout, "%s:\n", linkName(dirname, quoteName(dirname)))
+		return
+	}
+	stdout.WriteString(diredIndent)
+	start := stdout.offset
+	stdout.WriteString(quoteName(dirname))
+	diredSubdirs = append(diredSubdirs, start, stdout.offset)
+	stdout.WriteString(":\n")
+}
+
+func printDiredOffsets() {
+	printOffsets("//DIRED//", diredNames)
+	printOffsets("//SUBDIRED//", diredSubdirs)
+	fmt.Fprintf(stdout, "//DIRED-OPTIONS// --quoting-style=%s\n", quotingStyle)
+}
+
+func printOffsets(label string, offsets []int) {
+	if len(offsets) == 0 {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(label)
+	for _, off := range offsets {
+		b.WriteString(" " + strconv.Itoa(off))
+	}
+	fmt.Fprintln(stdout, b.String())
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+var (
+	gitRepos    = make(map[string]*gitRepo) // by work tree root
+	gitDirRepos = make(map[string]*gitRepo) // by listed directory
+)
+
+// gitStatusRank orders the status letters so a directory shows the most
+// significant state of its children.
+var gitStatusRank = map[byte]int{'-': 0, '!': 1, '?': 2, 'A': 3, 'D': 4, 'M': 5, 'U': 6}
+
+func worse(a, b byte) byte {
+	if gitStatusRank[b] > gitStatusRank[a] {
+		return b
+	}
+	return a
+}
+
+// repoFor returns the repository whose work tree contains dir, or nil.
+func repoFor(dir string) *gitRepo {
+	if repo, ok := gitDirRepos[dir]; ok {
+		return repo
+	}
+
+	var repo *gitRepo
+	if root, gitDir, ok := findGitDir(dir); ok {
+		var seen bool
+		if repo, seen = gitRepos[root]; !seen {
+			var err error
+			if repo, err = openGitRepo(root, gitDir); err != nil {
+				fail(false, "%s: cannot read git repository: %v", gitDir, err)
+				repo = nil
+			}
+			if repo != nil {
+				repo.init()
+			}
+			gitRepos[root] = repo
+		}
+	}
+	gitDirRepos[dir] = repo
+	return repo
+}
+
+// gitStatus returns the two letter status of e, staged then unstaged, or ""
+// when e is not inside a work tree.
+func gitStatus(e *fileEntry) string {
+	abs := absPath(e.path)
+	dir := filepath.Dir(abs)
+	if e.isDir() {
+		dir = abs
+	}
+	repo := repoFor(dir)
+	if repo == nil {
+		return ""
+	}
+	rel, err := filepath.Rel(repo.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".git" || strings.HasPrefix(rel, ".git/") {
+		return "--"
+	}
+
+	if e.isDir() {
+		return repo.dirStatus(rel)
+	}
+	return repo.fileStatus(rel)
+}
+
+// init prepares the lookups the status computation needs once per run.
+func (r *gitRepo) init() {
+	r.fileCache = make(map[string]string)
+	r.dirCache = make(map[string]string)
+	r.ignoreCache = make(map[string]*ignoreList)
+
+	for path := range r.head {
+		if _, ok := r.index[path]; !ok {
+			r.deleted = append(r.deleted, path)
+		}
+	}
+	sort.Strings(r.deleted)
+}
+
+func (r *gitRepo) fileStatus(rel string) string {
+	if status, ok := r.fileCache[rel]; ok {
+		return status
+	}
+
+	var status string
+	ie := r.index[rel]
+	he, inHead := r.head[rel]
+	switch {
+	case ie == nil && inHead:
+		// removed from the index but still in the work tree
+		status = "D?"
+	case ie == nil && r.ignored(rel, false):
+		status = "!!"
+	case ie == nil:
+		status = "??"
+	case ie.stage > 0:
+		status = "UU"
+	default:
+		staged := byte('-')
+		if !inHead {
+			staged = 'A'
+		} else if he.hash != ie.hash || he.mode != ie.mode {
+			staged = 'M'
+		}
+		status = string([]byte{staged, r.worktreeChange(rel, ie)})
+	}
+
+	r.fileCache[rel] = status
+	return status
+}
+
+// worktreeChange compares a file with its index entry, trusting matching
+// size and mtime the way git does and hashing the content otherwise.
+func (r *gitRepo) worktreeChange(rel string, ie *indexEntry) byte {
+	// submodules are not looked into
+	if ie.mode&0170000 == 0160000 {
+		return '-'
+	}
+
+	path := filepath.Join(r.root, filepath.FromSlash(rel))
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 'D'
+	}
+
+	mode := uint32(0100644)
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		mode = 0120000
+	case !info.Mode().IsRegular():
+		return 'M'
+	case info.Mode()&0111 != 0:
+		mode = 0100755
+	}
+	if mode != ie.mode {
+		return 'M'
+	}
+
+	if uint32(info.Size()) == ie.size {
+		st := info.Sys().(*syscall.Stat_t)
+		sec, nsec := mtimeOf(st)
+		if uint32(sec) == ie.mtimeSec && uint32(nsec) == ie.mtimeNsec {
+			return '-'
+		}
+	}
+
+	var content []byte
+	if mode == 0120000 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return 'M'
+		}
+		content = []byte(target)
+	} else if content, err = os.ReadFile(path); err != nil {
+		return 'M'
+	}
+	if r.blobHash(content) != ie.hash {
+		return 'M'
+	}
+	return '-'
+}
+
+// dirStatus aggregates the status of everything below rel.
+func (r *gitRepo) dirStatus(rel string) string {
+	if status, ok := r.dirCache[rel]; ok {
+		return status
+	}
+
+	prefix := rel + "/"
+	if rel == "." {
+		prefix = ""
+	}
+	tracked := prefixRange(r.indexKeys, prefix)
+	deleted := prefixRange(r.deleted, prefix)
+
+	var status string
+	switch {
+	case len(tracked) == 0 && len(deleted) == 0 && r.ignored(rel, true):
+		status = "!!"
+	case len(tracked) == 0 && len(deleted) == 0:
+		status = "??"
+	default:
+		staged, unstaged := byte('-'), byte('-')
+		for _, path := range tracked {
+			s := r.fileStatus(path)
+			staged, unstaged = worse(staged, s[0]), worse(unstaged, s[1])
+		}
+		if len(deleted) > 0 {
+			staged = worse(staged, 'D')
+		}
+		if unstaged == '-' && r.hasUntracked(rel) {
+			unstaged = '?'
+		}
+		status = string([]byte{staged, unstaged})
+	}
+
+	r.dirCache[rel] = status
+	return status
+}
+
+func prefixRange(sorted []string, prefix string) []string {
+	lo := sort.SearchStrings(sorted, prefix)
+	hi := lo + sort.Search(len(sorted)-lo, func(i int) bool {
+		return !strings.HasPrefix(sorted[lo+i], prefix)
+	})
+	return sorted[lo:hi]
+}
+
+// hasUntracked looks for a file below rel that is neither tracked nor
+// ignored, skipping ignored directories entirely.
+func (r *gitRepo) hasUntracked(rel string) bool {
+	dir := filepath.Join(r.root, filepath.FromSlash(rel))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, d := range entries {
+		child := d.Name()
+		if rel != "." {
+			child = rel + "/" + child
+		}
+		if child == ".git" {
+			continue
+		}
+		if d.IsDir() {
+			if !r.ignored(child, true) && r.hasUntracked(child) {
+				return true
+			}
+			continue
+		}
+		if _, ok := r.index[child]; !ok && !r.ignored(child, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored applies .git/info/exclude and the .gitignore files from the root
+// down, a path being ignored when it or any directory above it matches.
+func (r *gitRepo) ignored(rel string, isDir bool) bool {
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		parent := strings.Join(parts[:i], "/")
+		path := filepath.Join(r.root, filepath.FromSlash(strings.Join(parts[:i+1], "/")))
+		if r.ignoresFor(parent).match(path, i < len(parts)-1 || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *gitRepo) ignoresFor(rel string) *ignoreList {
+	if list, ok := r.ignoreCache[rel]; ok {
+		return list
+	}
+
+	var list *ignoreList
+	if rel == "" {
+		list = list.loadFile(filepath.Join(r.commonDir(), "info", "exclude"), r.root)
+		list = list.load(r.root, ".gitignore")
+	} else {
+		parent := ""
+		if i := strings.LastIndexByte(rel, '/'); i >= 0 {
+			parent = rel[:i]
+		}
+		list = r.ignoresFor(parent).load(filepath.Join(r.root, filepath.FromSlash(rel)), ".gitignore")
+	}
+
+	r.ignoreCache[rel] = list
+	return list
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gitRepo reads the parts of a repository --git needs straight from the
+// .git directory: the index, HEAD's tree, loose objects and packfiles.
+type gitRepo struct {
+	root     string // absolute path of the work tree
+	gitDir   string
+	hashSize int
+
+	index     map[string]*indexEntry
+	indexKeys []string // sorted index paths, for prefix lookups
+	head      map[string]treeEntry
+	packs     []*packFile
+
+	deleted     []string // sorted paths in HEAD but not in the index
+	fileCache   map[string]string
+	dirCache    map[string]string
+	ignoreCache map[string]*ignoreList
+}
+
+type indexEntry struct {
+	mtimeSec  uint32
+	mtimeNsec uint32
+	mode      uint32
+	size      uint32
+	hash      string
+	stage     int
+}
+
+type treeEntry struct {
+	mode uint32
+	hash string
+}
+
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+// findGitDir walks up from dir looking for a .git directory, or a .git file
+// pointing at one as used by worktrees and submodules.
+func findGitDir(dir string) (root, gitDir string, ok bool) {
+	for {
+		candidate := filepath.Join(dir, ".git")
+		info, err := os.Stat(candidate)
+		if err == nil && info.IsDir() {
+			return dir, candidate, true
+		}
+		if err == nil && info.Mode().IsRegular() {
+			if data, err := os.ReadFile(candidate); err == nil {
+				line := strings.TrimSpace(string(data))
+				if strings.HasPrefix(line, "gitdir: ") {
+					target := strings.TrimPrefix(line, "gitdir: ")
+					if !filepath.IsAbs(target) {
+						target = filepath.Join(dir, target)
+					}
+					return dir, target, true
+				}
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", false
+		}
+		dir = parent
+	}
+}
+
+func openGitRepo(root, gitDir string) (*gitRepo, error) {
+	repo := &gitRepo{root: root, gitDir: gitDir, hashSize: sha1.Size}
+	if repo.objectFormat() == "sha256" {
+		repo.hashSize = sha256.Size
+	}
+
+	if err := repo.readIndex(); err != nil {
+		return nil, err
+	}
+	repo.loadPacks()
+
+	repo.head = make(map[string]treeEntry)
+	if commit, err := repo.resolveHead(); err == nil {
+		tree, err := repo.commitTree(commit)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.flattenTree(tree, "", repo.head); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// commonDir is where objects and refs live; linked worktrees share them
+// with the main repository.
+func (r *gitRepo) commonDir() string {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "commondir"))
+	if err != nil {
+		return r.gitDir
+	}
+	dir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.gitDir, dir)
+	}
+	return dir
+}
+
+func (r *gitRepo) objectFormat() string {
+	f, err := os.Open(filepath.Join(r.commonDir(), "config"))
+	if err != nil {
+		return "sha1"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if found && strings.EqualFold(strings.TrimSpace(key), "objectformat") {
+			return strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	return "sha1"
+}
+
+func (r *gitRepo) newHash() hash.Hash {
+	if r.hashSize == sha256.Size {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// readIndex parses .git/index, versions 2 to 4.
+func (r *gitRepo) readIndex() error {
+	r.index = make(map[string]*indexEntry)
+
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "index"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return errors.New("bad index signature")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return fmt.Errorf("unsupported index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	pos := 12
+	prevPath := ""
+	for i := uint32(0); i < count; i++ {
+		start := pos
+		fixed := 40 + r.hashSize + 2
+		if pos+fixed > len(data) {
+			return errors.New("truncated index")
+		}
+		e := &indexEntry{
+			mtimeSec:  binary.BigEndian.Uint32(data[pos+8:]),
+			mtimeNsec: binary.BigEndian.Uint32(data[pos+12:]),
+			mode:      binary.BigEndian.Uint32(data[pos+24:]),
+			size:      binary.BigEndian.Uint32(data[pos+36:]),
+			hash:      hex.EncodeToString(data[pos+40 : pos+40+r.hashSize]),
+		}
+		flags := binary.BigEndian.Uint16(data[pos+40+r.hashSize:])
+		e.stage = int(flags>>12) & 3
+		pos += fixed
+		if version >= 3 && flags&0x4000 != 0 {
+			pos += 2
+		}
+
+		var path string
+		if version == 4 {
+			// the path drops a number of bytes from the end of the
+			// previous one and appends the rest
+			strip, n := offsetVarint(data[pos:])
+			if n <= 0 || int(strip) > len(prevPath) {
+				return errors.New("bad index path compression")
+			}
+			pos += n
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return errors.New("truncated index")
+			}
+			path = prevPath[:len(prevPath)-int(strip)] + string(data[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return errors.New("truncated index")
+			}
+			path = string(data[pos : pos+end])
+			// entries are NUL padded to a multiple of eight bytes
+			pos = start + (pos+end-start+8)&^7
+		}
+		prevPath = path
+
+		if old, ok := r.index[path]; !ok || e.stage > old.stage {
+			r.index[path] = e
+		}
+	}
+
+	r.indexKeys = make([]string, 0, len(r.index))
+	for path := range r.index {
+		r.indexKeys = append(r.indexKeys, path)
+	}
+	sort.Strings(r.indexKeys)
+	return nil
+}
+
+func (r *gitRepo) resolveHead() (string, error) {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	head := strings.TrimSpace(string(data))
+	for i := 0; i < 10 && strings.HasPrefix(head, "ref: "); i++ {
+		head, err = r.resolveRef(strings.TrimPrefix(head, "ref: "))
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(head) != r.hashSize*2 {
+		return "", fmt.Errorf("bad HEAD %q", head)
+	}
+	return head, nil
+}
+
+func (r *gitRepo) resolveRef(name string) (string, error) {
+	for _, dir := range []string{r.gitDir, r.commonDir()} {
+		if data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+
+	f, err := os.Open(filepath.Join(r.commonDir(), "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("unknown ref %s", name)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, ref, found := strings.Cut(scanner.Text(), " ")
+		if found && ref == name {
+			return hash, nil
+		}
+	}
+	// an unborn branch, as in a fresh repository
+	return "", fmt.Errorf("unknown ref %s", name)
+}
+
+func (r *gitRepo) commitTree(commit string) (string, error) {
+	typ, data, err := r.readObject(commit)
+	if err != nil {
+		return "", err
+	}
+	for typ == objTag {
+		target, _, _ := strings.Cut(strings.TrimPrefix(string(data), "object "), "\n")
+		if typ, data, err = r.readObject(target); err != nil {
+			return "", err
+		}
+	}
+	if typ != objCommit || !bytes.HasPrefix(data, []byte("tree ")) || len(data) < 5+r.hashSize*2 {
+		return "", fmt.Errorf("%s is not a commit", commit)
+	}
+	return string(data[5 : 5+r.hashSize*2]), nil
+}
+
+// flattenTree records every blob below tree under its slash separated path.
+func (r *gitRepo) flattenTree(tree, prefix string, out map[string]treeEntry) error {
+	typ, data, err := r.readObject(tree)
+	if err != nil {
+		return err
+	}
+	if typ != objTree {
+		return fmt.Errorf("%s is not a tree", tree)
+	}
+
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || nul+1+r.hashSize > len(data) {
+			return fmt.Errorf("corrupt tree %s", tree)
+		}
+		mode, _ := strconv.ParseUint(string(data[:sp]), 8, 32)
+		name := prefix + string(data[sp+1:nul])
+		hash := hex.EncodeToString(data[nul+1 : nul+1+r.hashSize])
+		data = data[nul+1+r.hashSize:]
+
+		switch mode & 0170000 {
+		case 0040000:
+			if err := r.flattenTree(hash, name+"/", out); err != nil {
+				return err
+			}
+		default:
+			out[name] = treeEntry{mode: uint32(mode), hash: hash}
+		}
+	}
+	return nil
+}
+
+func (r *gitRepo) readObject(hash string) (int, []byte, error) {
+	if typ, data, err := r.readLooseObject(hash); err == nil {
+		return typ, data, nil
+	}
+
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, p := range r.packs {
+		if offset, ok := p.find(raw); ok {
+			return p.readAt(r, offset)
+		}
+	}
+	return 0, nil, fmt.Errorf("object %s not found", hash)
+}
+
+func (r *gitRepo) readLooseObject(hash string) (int, []byte, error) {
+	// hash may come from a tag object, so it is not trusted as a path
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != r.hashSize*2 {
+		return 0, nil, fmt.Errorf("bad object name %q", hash)
+	}
+	f, err := os.Open(filepath.Join(r.commonDir(), "objects", hash[:2], hash[2:]))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return 0, nil, fmt.Errorf("corrupt object %s", hash)
+	}
+	kind, _, _ := strings.Cut(string(data[:nul]), " ")
+
+	types := map[string]int{"commit": objCommit, "tree": objTree, "blob": objBlob, "tag": objTag}
+	typ, ok := types[kind]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown object type %q", kind)
+	}
+	return typ, data[nul+1:], nil
+}
+
+// blobHash computes the object name git gives to content.
+func (r *gitRepo) blobHash(content []byte) string {
+	h := r.newHash()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// packFile is a .pack with its version 2 .idx.
+type packFile struct {
+	path    string
+	fanout  [256]uint32
+	hashes  []byte
+	offsets []byte
+	large   []byte
+	size    int
+}
+
+func (r *gitRepo) loadPacks() {
+	idxs, _ := filepath.Glob(filepath.Join(r.commonDir(), "objects", "pack", "*.idx"))
+	for _, idx := range idxs {
+		if p, err := openPack(idx, r.hashSize); err == nil {
+			r.packs = append(r.packs, p)
+		}
+	}
+}
+
+func openPack(idx string, hashSize int) (*packFile, error) {
+	data, err := os.ReadFile(idx)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte{0xff, 't', 'O', 'c'}) || binary.BigEndian.Uint32(data[4:]) != 2 {
+		return nil, errors.New("unsupported pack index")
+	}
+
+	p := &packFile{path: strings.TrimSuffix(idx, ".idx") + ".pack", size: hashSize}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(data[8+i*4:])
+	}
+	n := int(p.fanout[255])
+	pos := 8 + 256*4
+	if len(data) < pos+n*(hashSize+8) {
+		return nil, errors.New("truncated pack index")
+	}
+	p.hashes = data[pos : pos+n*hashSize]
+	pos += n * hashSize
+	pos += n * 4 // CRCs
+	p.offsets = data[pos : pos+n*4]
+	p.large = data[pos+n*4:]
+	return p, nil
+}
+
+func (p *packFile) find(hash []byte) (int64, bool) {
+	lo := 0
+	if hash[0] > 0 {
+		lo = int(p.fanout[hash[0]-1])
+	}
+	hi := int(p.fanout[hash[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.hashes[(lo+i)*p.size:(lo+i+1)*p.size], hash) >= 0
+	})
+	if i >= hi || !bytes.Equal(p.hashes[i*p.size:(i+1)*p.size], hash) {
+		return 0, false
+	}
+
+	offset := binary.BigEndian.Uint32(p.offsets[i*4:])
+	if offset&0x80000000 != 0 {
+		j := int(offset & 0x7fffffff)
+		return int64(binary.BigEndian.Uint64(p.large[j*8:])), true
+	}
+	return int64(offset), true
+}
+
+// readAt inflates the object at offset, resolving delta chains.
+func (p *packFile) readAt(r *gitRepo, offset int64) (int, []byte, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := int(c>>4) & 7
+	for c&0x80 != 0 {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var baseType int
+	var base []byte
+	switch typ {
+	case objOfsDelta:
+		var buf [10]byte
+		n := 0
+		for ; n < len(buf); n++ {
+			if buf[n], err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			if buf[n]&0x80 == 0 {
+				break
+			}
+		}
+		if n == len(buf) {
+			return 0, nil, errors.New("corrupt delta offset")
+		}
+		// the base comes before the delta, which also rules out loops
+		rel, _ := offsetVarint(buf[:n+1])
+		if rel <= 0 || rel > offset {
+			return 0, nil, errors.New("corrupt delta offset")
+		}
+		if baseType, base, err = p.readAt(r, offset-rel); err != nil {
+			return 0, nil, err
+		}
+	case objRefDelta:
+		ref := make([]byte, p.size)
+		if _, err := io.ReadFull(br, ref); err != nil {
+			return 0, nil, err
+		}
+		if baseType, base, err = r.readObject(hex.EncodeToString(ref)); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if base == nil {
+		return typ, data, nil
+	}
+	data, err = applyDelta(base, data)
+	return baseType, data, err
+}
+
+// offsetVarint decodes the big-endian varint git uses for delta base
+// offsets and index v4 path prefixes, where each continuation adds one.
+func offsetVarint(buf []byte) (int64, int) {
+	if len(buf) == 0 {
+		return 0, 0
+	}
+	v := int64(buf[0] & 0x7f)
+	i := 1
+	for buf[i-1]&0x80 != 0 {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		v = (v+1)<<7 | int64(buf[i]&0x7f)
+		i++
+	}
+	return v, i
+}
+
+func applyDelta(base, delta []byte) ([]byte, error) {
+	errCorrupt := errors.New("corrupt delta")
+
+	srcSize, n := binary.Uvarint(delta)
+	if n <= 0 || srcSize != uint64(len(base)) {
+		return nil, errCorrupt
+	}
+	delta = delta[n:]
+	dstSize, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	delta = delta[n:]
+
+	// the size is only a hint until checked at the end
+	capacity := uint64(len(base) + len(delta))
+	if dstSize < capacity {
+		capacity = dstSize
+	}
+	out := make([]byte, 0, capacity)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		if op&0x80 == 0 {
+			if op == 0 || int(op) > len(delta) {
+				return nil, errCorrupt
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+			continue
+		}
+
+		// copy from base, offset and size bytes are present per bit
+		var offset, size uint64
+		for i := uint(0); i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errCorrupt
+			}
+			if i < 4 {
+				offset |= uint64(delta[0]) << (8 * i)
+			} else {
+				size |= uint64(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > uint64(len(base)) {
+			return nil, errCorrupt
+		}
+		out = append(out, base[offset:offset+size]...)
+	}
+
+	if uint64(len(out)) != dstSize {
+		return nil, errCorrupt
+	}
+	return out, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	layoutOnePerLine = iota
+	layoutColumns
+	layoutAcross
+)
+
+const columnGap = 2
+
+var (
+	layout    = layoutOnePerLine
+	lineWidth = 80
+)
+
+// isTerminal reports whether f is a terminal; other character devices, like
+// /dev/null, are not.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlGetTermios)
+	return err == nil
+}
+
+// setLayout picks the short format layout. Like GNU ls, output to a terminal
+// defaults to columns sized to the window.
+func setLayout() {
+	switch {
+	case *oneFlag:
+		layout = layoutOnePerLine
+	case *xFlag:
+		layout = layoutAcross
+	case *CFlag || isTerminal(os.Stdout):
+		layout = layoutColumns
+	}
+
+	if *wFlag > 0 {
+		lineWidth = *wFlag
+	} else if *wFlag == 0 && isFlagSet("w", "width") {
+		lineWidth = int(^uint(0) >> 1)
+	} else if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		lineWidth = cols
+	} else if ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 {
+		lineWidth = int(ws.Col)
+	}
+}
+
+func isFlagSet(names ...string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				set = true
+			}
+		}
+	})
+	return set
+}
+
+func printShort(entries []*fileEntry) {
+	var inodes, blocks column
+	inodes.right, blocks.right = true, true
+	for _, e := range entries {
+		if *iFlag {
+			inodes.add(inodeNumber(e))
+		}
+		if *sFlag {
+			blocks.add(allocatedSize(e))
+		}
+	}
+
+	cells := make([]string, len(entries))
+	for i, e := range entries {
+		var b strings.Builder
+		if *iFlag {
+			b.WriteString(inodes.format(i) + " ")
+		}
+		if *sFlag {
+			b.WriteString(blocks.format(i) + " ")
+		}
+		b.WriteString(formatFile(e))
+		cells[i] = b.String()
+	}
+
+	if layout == layoutOnePerLine {
+		for _, cell := range cells {
+			fmt.Fprintln(stdout, cell)
+		}
+		return
+	}
+	printGrid(cells)
+}
+
+// printGrid lays cells out in as many columns as fit in lineWidth, each
+// column as wide as its widest cell.
+func printGrid(cells []string) {
+	if len(cells) == 0 {
+		return
+	}
+	widths := make([]int, len(cells))
+	for i, cell := range cells {
+		widths[i] = displayWidth(cell)
+	}
+
+	cols, rows, colWidths := 1, len(cells), []int{0}
+	for _, w := range widths {
+		if w > colWidths[0] {
+			colWidths[0] = w
+		}
+	}
+
+	// the narrowest possible column is one character plus the gap
+	maxCols := lineWidth / (1 + columnGap)
+	if maxCols > len(cells) {
+		maxCols = len(cells)
+	}
+	for try := maxCols; try > 1; try-- {
+		tryRows := (len(cells) + try - 1) / try
+		tryWidths := make([]int, try)
+		for i, w := range widths {
+			c := i / tryRows
+			if layout == layoutAcross {
+				c = i % try
+			}
+			if w > tryWidths[c] {
+				tryWidths[c] = w
+			}
+		}
+
+		total := 0
+		for _, w := range tryWidths {
+			total += w + columnGap
+		}
+		if total-columnGap <= lineWidth {
+			cols, rows, colWidths = try, tryRows, tryWidths
+			break
+		}
+	}
+
+	for r := 0; r < rows; r++ {
+		var b strings.Builder
+		for c := 0; c < cols; c++ {
+			i := c*rows + r
+			if layout == layoutAcross {
+				i = r*cols + c
+			}
+			if i >= len(cells) {
+				break
+			}
+			b.WriteString(cells[i])
+			next := (c+1)*rows + r
+			if layout == layoutAcross {
+				next = i + 1
+			}
+			if c < cols-1 && next < len(cells) {
+				b.WriteString(strings.Repeat(" ", colWidths[c]-widths[i]+columnGap))
+			}
+		}
+		fmt.Fprintln(stdout, b.String())
+	}
+}
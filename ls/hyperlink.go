@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// hyperlinkOption is the value of --hyperlink[=WHEN]; a bare --hyperlink
+// means always.
+type hyperlinkOption string
+
+func (h *hyperlinkOption) IsBoolFlag() bool {
+	return true
+}
+
+func (h *hyperlinkOption) String() string {
+	if h == nil {
+		return ""
+	}
+	return string(*h)
+}
+
+// Set accepts the same words as GNU ls, including its aliases.
+func (h *hyperlinkOption) Set(s string) error {
+	switch s {
+	case "true", "always", "yes", "force":
+		*h = "always"
+	case "false", "never", "no", "none":
+		*h = "never"
+	case "auto", "tty", "if-tty":
+		*h = "auto"
+	default:
+		return fmt.Errorf("invalid argument '%s' for '--hyperlink'", s)
+	}
+	return nil
+}
+
+var (
+	hyperlinkMode hyperlinkOption = "never"
+	hyperlinks    bool
+	hostname      string
+)
+
+func setHyperlinks() {
+	hyperlinks = hyperlinkMode == "always" || hyperlinkMode == "auto" && isTerminal(os.Stdout)
+	if hyperlinks {
+		hostname, _ = os.Hostname()
+	}
+}
+
+// linkName wraps an already quoted name in an OSC 8 escape pointing at
+// path, when hyperlinks are on.
+func linkName(path, name string) string {
+	if !hyperlinks {
+		return name
+	}
+	url := "file://" + escapeURLPath(hostname) + escapeURLPath(absPath(path))
+	return "\x1b]8;;" + url + "\a" + name + "\x1b]8;;\a"
+}
+
+// escapeURLPath percent-encodes everything but RFC 3986 unreserved
+// characters and slashes.
+func escapeURLPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("-._~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// displayWidth counts the columns s takes on screen, skipping the OSC 8
+// escapes around linked names.
+func displayWidth(s string) int {
+	width := 0
+	for len(s) > 0 {
+		if strings.HasPrefix(s, "\x1b]8;") {
+			if end := strings.IndexByte(s, '\a'); end >= 0 {
+				s = s[end+1:]
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		width++
+	}
+	return width
+}
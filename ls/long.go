@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	userNames  = make(map[uint32]string)
+	groupNames = make(map[uint32]string)
+)
+
+// userName resolves uid once per run; user.LookupId parses the passwd
+// database on every call.
+func userName(uid uint32) string {
+	if *nFlag {
+		return strconv.FormatUint(uint64(uid), 10)
+	}
+	if name, ok := userNames[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	userNames[uid] = name
+	return name
+}
+
+func groupName(gid uint32) string {
+	if *nFlag {
+		return strconv.FormatUint(uint64(gid), 10)
+	}
+	if name, ok := groupNames[gid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	groupNames[gid] = name
+	return name
+}
+
+// column is one aligned field of the long listing.
+type column struct {
+	cells []string
+	width int
+	right bool
+}
+
+func (c *column) add(cell string) {
+	c.cells = append(c.cells, cell)
+	if w := utf8.RuneCountInString(cell); w > c.width {
+		c.width = w
+	}
+}
+
+func (c *column) format(i int) string {
+	pad := strings.Repeat(" ", c.width-utf8.RuneCountInString(c.cells[i]))
+	if c.right {
+		return pad + c.cells[i]
+	}
+	return c.cells[i] + pad
+}
+
+func inodeNumber(e *fileEntry) string {
+	return strconv.FormatUint(uint64(e.sys().Ino), 10)
+}
+
+func allocatedSize(e *fileEntry) string {
+	return formatSize(e.sys().Blocks*512, &blockFormat)
+}
+
+func printTotal(entries []*fileEntry) {
+	var total int64
+	for _, e := range entries {
+		total += e.sys().Blocks * 512
+	}
+	if *diredFlag {
+		stdout.WriteString(diredIndent)
+	}
+	fmt.Fprintf(stdout, "total %s\n", formatSize(total, &blockFormat))
+}
+
+func printEntries(entries []*fileEntry) {
+	setPadUnquoted(entries)
+	if *lFlag {
+		printLong(entries)
+	} else {
+		printShort(entries)
+	}
+}
+
+func printLong(entries []*fileEntry) {
+	fields := longFields(entries)
+	for i, e := range entries {
+		printLongLine(fields[i], e)
+	}
+}
+
+// longFields renders the aligned columns preceding the name of each entry,
+// including the separating space.
+func longFields(entries []*fileEntry) []string {
+	// GNU ls only reserves the eleventh mode column when at least one
+	// file in the listing has an ACL or a security context
+	indicators := make([]byte, len(entries))
+	anyIndicator := false
+	for i, e := range entries {
+		indicators[i] = aclIndicator(e.path)
+		if indicators[i] != ' ' {
+			anyIndicator = true
+		}
+	}
+
+	var columns []*column
+	newColumn := func(right bool) *column {
+		c := &column{right: right}
+		columns = append(columns, c)
+		return c
+	}
+
+	var inodes, blocks, owners, groups, authors, contexts *column
+	if *iFlag {
+		inodes = newColumn(true)
+	}
+	if *sFlag {
+		blocks = newColumn(true)
+	}
+	modes := newColumn(false)
+	links := newColumn(true)
+	if !*gFlag {
+		owners = newColumn(false)
+	}
+	if !*oFlag {
+		groups = newColumn(false)
+	}
+	if *authorFlag {
+		authors = newColumn(false)
+	}
+	if *ZFlag {
+		contexts = newColumn(false)
+	}
+	sizes := newColumn(true)
+	times := newColumn(false)
+
+	// the status column only appears when something listed is in a repository
+	var statuses *column
+	var gitStatuses []string
+	if *gitFlag {
+		gitStatuses = make([]string, len(entries))
+		for i, e := range entries {
+			gitStatuses[i] = gitStatus(e)
+			if gitStatuses[i] != "" && statuses == nil {
+				statuses = newColumn(false)
+			}
+		}
+	}
+
+	for i, e := range entries {
+		st := e.sys()
+		if inodes != nil {
+			inodes.add(inodeNumber(e))
+		}
+		if blocks != nil {
+			blocks.add(allocatedSize(e))
+		}
+
+		mode := formatPermissions(e.info.Mode())
+		if anyIndicator {
+			mode += string(indicators[i])
+		}
+		modes.add(mode)
+		links.add(strconv.FormatUint(uint64(st.Nlink), 10))
+
+		if owners != nil {
+			owners.add(userName(st.Uid))
+		}
+		if groups != nil {
+			groups.add(groupName(st.Gid))
+		}
+		// there is no separate author on Linux, it is always the owner
+		if authors != nil {
+			authors.add(userName(st.Uid))
+		}
+		if contexts != nil {
+			contexts.add(contextOrUnknown(e.path))
+		}
+		sizes.add(formatSize(e.info.Size(), &sizeFormat))
+		times.add(formatTime(e.time()))
+		if statuses != nil {
+			statuses.add(gitStatuses[i])
+		}
+	}
+
+	fields := make([]string, len(entries))
+	for i := range entries {
+		var b strings.Builder
+		for _, c := range columns {
+			b.WriteString(c.format(i))
+			b.WriteByte(' ')
+		}
+		fields[i] = b.String()
+	}
+	return fields
+}
+
+// printLongLine writes one line of the long format, recording where the
+// name lands for --dired.
+func printLongLine(fields string, e *fileEntry) {
+	if *diredFlag {
+		stdout.WriteString(diredIndent)
+	}
+	stdout.WriteString(fields + namePad(e))
+	start := stdout.offset
+	stdout.WriteString(linkName(e.path, quoteName(e.name)))
+	if *diredFlag {
+		diredNames = append(diredNames, start, stdout.offset)
+	}
+	fmt.Fprintln(stdout, longSuffix(e))
+	if *xattrFlag {
+		printXattrs(e)
+	}
+}
+
+// longSuffix renders what follows the name in the long format: the
+// symlink target or the indicator.
+func longSuffix(e *fileEntry) string {
+	var b strings.Builder
+	if e.info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(e.path)
+		if err != nil {
+			target = "???"
+		}
+		b.WriteString(" -> " + quoteName(target))
+		// the link is marked through its target, which may be gone
+		if info, err := os.Stat(e.path); err == nil {
+			b.WriteString(indicator(info.Mode()))
+		}
+	} else {
+		b.WriteString(indicator(e.info.Mode()))
+	}
+	return b.String()
+}
@@ -1,34 +1,130 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"os/user"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 )
 
 var (
 	lFlag   = flag.Bool("l", false, "use a long listing format")
 	aFlag   = flag.Bool("a", false, "do not ignore entries starting with .")
 	hFlag   = flag.Bool("h", false, "with -l and -s, print sizes like 1K 234M 2G etc.")
+	kFlag   = flag.Bool("k", false, "default to 1024-byte blocks for file system usage; used only with -s and per directory totals")
 	RFlag   = flag.Bool("R", false, "list subdirectories recursively")
-	tFlag   = flag.Bool("t", false, "sort by modification time, newest first;")
+	tFlag   = flag.Bool("t", false, "sort by time, newest first; see --time")
+	uFlag   = flag.Bool("u", false, "with -lt: sort by, and show, access time; with -l: show access time and sort by name; otherwise: sort by access time, newest first")
+	cFlag   = flag.Bool("c", false, "with -lt: sort by, and show, ctime; with -l: show ctime and sort by name; otherwise: sort by ctime, newest first")
 	dFlag   = flag.Bool("d", false, "list directories themselves, not their contents")
 	oneFlag = flag.Bool("1", false, "list one file per line")
 	rFlag   = flag.Bool("r", false, "reverse order while sorting")
+	ZFlag   = flag.Bool("Z", false, "print any security context of each file")
+	iFlag   = flag.Bool("i", false, "print the index number of each file")
+	sFlag   = flag.Bool("s", false, "print the allocated size of each file, in blocks")
+	nFlag   = flag.Bool("n", false, "like -l, but list numeric user and group IDs")
+	gFlag   = flag.Bool("g", false, "like -l, but do not list owner")
+	oFlag   = flag.Bool("o", false, "like -l, but do not list group information")
+	UFlag   = flag.Bool("U", false, "do not sort; list entries in directory order")
+	SFlag   = flag.Bool("S", false, "sort by file size, largest first")
+	XFlag   = flag.Bool("X", false, "sort alphabetically by entry extension")
+	vFlag   = flag.Bool("v", false, "natural sort of (version) numbers within text")
+	LFlag   = flag.Bool("L", false, "show information for the file symbolic links reference, and follow them with -R")
+	AFlag   = flag.Bool("A", false, "do not list implied . and ..")
+	BFlag   = flag.Bool("B", false, "do not list implied entries ending with ~")
+	FFlag   = flag.Bool("F", false, "append indicator (one of */=@|) to entries")
+	pFlag   = flag.Bool("p", false, "append / indicator to directories")
+	CFlag   = flag.Bool("C", false, "list entries by columns")
+	xFlag   = flag.Bool("x", false, "list entries by lines instead of by columns")
+	wFlag   = flag.Int("w", 0, "set output width to COLS. 0 means no limit")
+	bFlag   = flag.Bool("b", false, "print C-style escapes for nongraphic characters")
+	qFlag   = flag.Bool("q", false, "print ? instead of nongraphic characters")
+	NFlag   = flag.Bool("N", false, "print entry names without quoting")
+	QFlag   = flag.Bool("Q", false, "enclose entry names in double quotes")
+
+	authorFlag       = flag.Bool("author", false, "with -l, print the author of each file")
+	sortFlag         = flag.String("sort", "", "sort by WORD instead of name: none (-U), size (-S), time (-t), version (-v), extension (-X), width")
+	dirsFirstFlag    = flag.Bool("group-directories-first", false, "group directories before files")
+	gitignoreFlag    = flag.Bool("gitignore", false, "do not list entries ignored by .gitignore files")
+	gitFlag          = flag.Bool("git", false, "show the git status of each file")
+	diredFlag        = flag.Bool("dired", false, "generate output designed for Emacs' dired mode")
+	xattrFlag        = flag.Bool("@", false, "with -l, list the extended attributes of each file and their sizes")
+	timeFlag         = flag.String("time", "", "select which timestamp is used to display or sort: atime, ctime, birth, mtime")
+	fullTimeFlag     = flag.Bool("full-time", false, "like -l with full-iso timestamps")
+	blockSizeFlag    = flag.String("block-size", "", "with -l, scale sizes by SIZE when printing them")
+	siFlag           = flag.Bool("si", false, "likewise, but use powers of 1000 not 1024")
+	fileTypeFlag     = flag.Bool("file-type", false, "likewise, except do not append '*'")
+	quotingStyleFlag = flag.String("quoting-style", "", "use quoting style WORD for entry names: literal, shell, shell-always, shell-escape, shell-escape-always, c, escape")
+	showControlFlag  = flag.Bool("show-control-chars", false, "show nongraphic characters as-is")
+	indicatorFlag    = flag.String("indicator-style", "", "append indicator with style WORD to entry names: none, slash (-p), file-type (--file-type), classify (-F)")
 )
 
-func quote(s string) string {
-	if strings.ContainsAny(s, " \t\n\"'\\") {
-		return strconv.Quote(s)
+var ignorePatterns, hidePatterns patternList
+
+func init() {
+	flag.Var(&hyperlinkMode, "hyperlink", "hyperlink file names WHEN: always (default without WHEN), auto, never")
+	flag.Var(&tree, "tree", "list subdirectories recursively as a tree, down to DEPTH levels if given")
+	flag.BoolVar(xattrFlag, "xattr", false, "with -l, list the extended attributes of each file and their sizes")
+	flag.BoolVar(FFlag, "classify", false, "append indicator (one of */=@|) to entries")
+	flag.IntVar(wFlag, "width", 0, "set output width to COLS. 0 means no limit")
+	flag.BoolVar(hFlag, "human-readable", false, "with -l and -s, print sizes like 1K 234M 2G etc.")
+	flag.BoolVar(kFlag, "kibibytes", false, "default to 1024-byte blocks for file system usage; used only with -s and per directory totals")
+	flag.BoolVar(bFlag, "escape", false, "print C-style escapes for nongraphic characters")
+	flag.BoolVar(qFlag, "hide-control-chars", false, "print ? instead of nongraphic characters")
+	flag.BoolVar(NFlag, "literal", false, "print entry names without quoting")
+	flag.BoolVar(QFlag, "quote-name", false, "enclose entry names in double quotes")
+	flag.BoolVar(BFlag, "ignore-backups", false, "do not list implied entries ending with ~")
+	flag.Var(&ignorePatterns, "I", "do not list implied entries matching shell PATTERN")
+	flag.Var(&ignorePatterns, "ignore", "do not list implied entries matching shell PATTERN")
+	flag.Var(&hidePatterns, "hide", "do not list implied entries matching shell PATTERN (overridden by -a or -A)")
+}
+
+// patternList collects the values of a repeatable flag.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+type fileEntry struct {
+	name string      // name as it is displayed
+	path string      // path used to reach the file
+	typ  os.FileMode // type bits, known even before the entry is stat'ed
+	info os.FileInfo // nil until stat'ed, see needStat
+
+	birth     *time.Time // nil if the filesystem has no birth time
+	birthRead bool
+}
+
+func newEntry(name, path string, info os.FileInfo) *fileEntry {
+	return &fileEntry{name: name, path: path, typ: info.Mode().Type(), info: info}
+}
+
+// mode returns the full mode when the entry was stat'ed, its type otherwise.
+func (e *fileEntry) mode() os.FileMode {
+	if e.info != nil {
+		return e.info.Mode()
 	}
-	return s
+	return e.typ
+}
+
+func (e *fileEntry) isDir() bool {
+	return e.typ.IsDir()
+}
+
+func (e *fileEntry) sys() *syscall.Stat_t {
+	return e.info.Sys().(*syscall.Stat_t)
 }
 
 func formatPermissions(mode os.FileMode) string {
@@ -37,16 +133,6 @@ func formatPermissions(mode os.FileMode) string {
 	b.WriteString(fileType(mode))
 	b.WriteString(filePermissions(mode))
 
-	if mode&os.ModeSticky != 0 {
-		b.WriteRune('t')
-	} else if mode&os.ModeSetuid != 0 {
-		b.WriteRune('s')
-	} else if mode&os.ModeSetgid != 0 {
-		b.WriteRune('s')
-	} else {
-		b.WriteRune('-')
-	}
-
 	return b.String()
 }
 
@@ -78,9 +164,28 @@ func filePermissions(mode os.FileMode) string {
 	fillPerms(perms[:], 0, mode.Perm()>>6)
 	fillPerms(perms[:], 3, (mode.Perm()>>3)&7)
 	fillPerms(perms[:], 6, mode.Perm()&7)
+
+	// the special bits replace the execute slot they belong to, in upper
+	// case when the underlying execute bit is not set
+	if mode&os.ModeSetuid != 0 {
+		perms[2] = specialPerm(perms[2], 's')
+	}
+	if mode&os.ModeSetgid != 0 {
+		perms[5] = specialPerm(perms[5], 's')
+	}
+	if mode&os.ModeSticky != 0 {
+		perms[8] = specialPerm(perms[8], 't')
+	}
 	return string(perms[:])
 }
 
+func specialPerm(exec rune, c rune) rune {
+	if exec == 'x' {
+		return c
+	}
+	return unicode.ToUpper(c)
+}
+
 func fillPerms(perms []rune, start int, p os.FileMode) {
 	if p&4 != 0 {
 		perms[start] = 'r'
@@ -99,147 +204,341 @@ func fillPerms(perms []rune, start int, p os.FileMode) {
 	}
 }
 
-func formatSize(size int64) string {
-	if *hFlag {
-		const unit = 1024
-		if size < unit {
-			return fmt.Sprintf("%d", size)
-		}
-		div, exp := int64(unit), 0
-		for n := size / unit; n >= unit; n /= unit {
-			div *= unit
-			exp++
+var indicatorStyle = "none"
+
+// indicator returns the suffix --indicator-style asks for, derived from the
+// same type letter the long format shows.
+func indicator(mode os.FileMode) string {
+	if indicatorStyle == "none" {
+		return ""
+	}
+	switch fileType(mode) {
+	case "d":
+		return "/"
+	}
+	if indicatorStyle == "slash" {
+		return ""
+	}
+	switch fileType(mode) {
+	case "l":
+		return "@"
+	case "p":
+		return "|"
+	case "s":
+		return "="
+	case "-":
+		if indicatorStyle == "classify" && mode&0111 != 0 {
+			return "*"
 		}
-		return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
-	} else {
-		return fmt.Sprintf("%d", size)
 	}
+	return ""
 }
 
-func formatFile(file os.FileInfo, path string) string {
-	linkInfo := ""
-	if file.Mode().IsRegular() && file.Mode()&os.ModeSymlink != 0 {
-		linkDest, err := os.Readlink(path)
-		if err != nil {
-			linkDest = "???"
-		}
-		linkInfo = fmt.Sprintf(" -> %s", linkDest)
-	}
-
-	if *lFlag || *oneFlag {
-		uid := file.Sys().(*syscall.Stat_t).Uid
-		gid := file.Sys().(*syscall.Stat_t).Gid
-		u, _ := user.LookupId(strconv.Itoa(int(uid)))
-		g, _ := user.LookupGroupId(strconv.Itoa(int(gid)))
-		userName := "-"
-		groupName := "-"
-		if u != nil {
-			userName = u.Username
+func formatFile(e *fileEntry) string {
+	name := entryName(e) + indicator(e.mode())
+	if *ZFlag {
+		name = contextOrUnknown(e.path) + " " + name
+	}
+	if *gitFlag && !*lFlag {
+		if status := gitStatus(e); status != "" {
+			name = status + " " + name
 		}
+	}
+	return name
+}
 
-		if g != nil {
-			groupName = g.Name
-		}
-		return fmt.Sprintf(
-			"%v %d %s %s %s %s %s%s",
-			formatPermissions(file.Mode()),
-			file.Sys().(*syscall.Stat_t).Nlink,
-			userName,
-			groupName,
-			formatSize(file.Size()),
-			file.ModTime().Format(time.Stamp),
-			quote(file.Name()),
-			linkInfo,
-		)
+func contextOrUnknown(path string) string {
+	if context := securityContext(path); context != "" {
+		return context
+	}
+	return "?"
+}
 
-	} else {
-		return quote(file.Name()) + linkInfo
+// exitStatus follows GNU ls: 1 for minor problems such as an unreadable
+// subdirectory, 2 for serious trouble such as an inaccessible operand.
+var exitStatus int
+
+// output counts the bytes written so far, which --dired reports
+// positions in.
+type output struct {
+	*bufio.Writer
+	offset int
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	n, err := o.Writer.Write(p)
+	o.offset += n
+	return n, err
+}
+
+func (o *output) WriteString(s string) (int, error) {
+	n, err := o.Writer.WriteString(s)
+	o.offset += n
+	return n, err
+}
+
+var stdout = &output{Writer: bufio.NewWriter(os.Stdout)}
+
+func fail(serious bool, format string, a ...interface{}) {
+	stdout.Flush()
+	fmt.Fprintf(os.Stderr, "ls: "+format+"\n", a...)
+	if serious {
+		exitStatus = 2
+	} else if exitStatus == 0 {
+		exitStatus = 1
+	}
+}
+
+// errorText returns the bare system error message of err, capitalized the
+// way strerror(3) reports it.
+func errorText(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		msg := errno.Error()
+		return strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	return err.Error()
+}
+
+type dirID struct {
+	dev, ino uint64
+}
+
+var (
+	// activeDirs holds the directories on the current -R path, so a
+	// symlink loop followed with -L is reported instead of recursed into
+	activeDirs = make(map[dirID]bool)
+
+	printHeaders bool
+	printedBlock bool
+)
+
+var workDir string
+
+func absPath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
 	}
+	if workDir == "" {
+		workDir, _ = os.Getwd()
+	}
+	return filepath.Join(workDir, path)
 }
 
-func ls(dirname string, recursive bool) {
-	file, err := os.Open(dirname)
+func dirIDOf(path string) (dirID, error) {
+	info, err := os.Stat(path)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return dirID{}, err
 	}
+	st := info.Sys().(*syscall.Stat_t)
+	return dirID{uint64(st.Dev), uint64(st.Ino)}, nil
+}
+
+// loadIgnoreFiles adds the ignore files found in dir to ignores.
+func loadIgnoreFiles(ignores *ignoreList, dir string) *ignoreList {
+	ignores = ignores.load(dir, ".lsignore")
+	if *gitignoreFlag {
+		ignores = ignores.load(dir, ".gitignore")
+	}
+	return ignores
+}
 
-	files, err := file.Readdir(-1) // -1 means read all files
+func ls(dirname string, commandLine bool, ignores *ignoreList) {
+	id, err := dirIDOf(dirname)
 	if err != nil {
-		fmt.Println(err)
+		fail(commandLine, "cannot access '%s': %s", dirname, errorText(err))
 		return
 	}
+	if activeDirs[id] {
+		fail(true, "%s: not listing already-listed directory", dirname)
+		return
+	}
+	activeDirs[id] = true
+	defer delete(activeDirs, id)
 
-	totalSize := int64(0)
-
-	if *aFlag {
-		dot, err := os.Stat(".")
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		dotDot, err := os.Stat("..")
-		if err != nil {
-			fmt.Println(err)
-			return
+	if printHeaders {
+		if printedBlock {
+			fmt.Fprintln(stdout)
 		}
-
-		files = append([]os.FileInfo{dot, dotDot}, files...)
+		printHeader(dirname)
 	}
+	printedBlock = true
 
-	if *lFlag {
-		for _, file := range files {
-			totalSize += file.Sys().(*syscall.Stat_t).Blocks
+	// when streaming, the entries have already been printed and only the
+	// directories among them are returned
+	ignores = loadIgnoreFiles(ignores, dirname)
+	entries := readDirectory(dirname, commandLine, ignores)
+	if !streaming() {
+		if needStat() {
+			entries = statEntries(entries)
 		}
-		fmt.Printf("total %d\n", totalSize)
-	}
+		sortEntries(entries)
 
-	if *dFlag {
-		info, err := os.Stat(dirname)
-		if err != nil {
-			fmt.Println(err)
-			return
+		if *lFlag || *sFlag {
+			printTotal(entries)
 		}
-		files = []os.FileInfo{info}
+		printEntries(entries)
 	}
 
-	if *tFlag {
-		sort.Slice(files, func(i, j int) bool {
-			return files[i].ModTime().After(files[j].ModTime())
-		})
+	// subdirectories are listed after the whole parent block, in the
+	// order they were displayed
+	if *RFlag {
+		for _, e := range entries {
+			if e.isDir() && e.name != "." && e.name != ".." {
+				ls(e.path, false, ignores)
+			}
+		}
 	}
+}
 
-	if *rFlag {
-		sort.Slice(files, func(i, j int) bool {
-			return files[i].ModTime().Before(files[j].ModTime())
-		})
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// listed reports whether a directory member passes the -a/-A, -B, -I,
+// --hide and .lsignore filters.
+func listed(e *fileEntry, ignores *ignoreList) bool {
+	all := *aFlag || *AFlag
+	switch {
+	case isHidden(e.name) && !all:
+		return false
+	case *BFlag && strings.HasSuffix(e.name, "~"):
+		return false
+	case matchesAny(ignorePatterns, e.name):
+		return false
+	case !all && matchesAny(hidePatterns, e.name):
+		return false
 	}
+	return !ignores.match(e.path, e.isDir())
+}
 
-	for _, file := range files {
-		if *aFlag || !isHidden(file) {
-			fmt.Println(formatFile(file, filepath.Join(dirname, file.Name())))
+// matchesAny matches name against shell patterns the way fnmatch does with
+// FNM_PERIOD: a leading dot has to be matched explicitly.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if isHidden(name) && !isHidden(pattern) {
+			continue
 		}
-
-		if recursive && file.IsDir() {
-			subDir := filepath.Join(dirname, file.Name())
-			fmt.Printf("\n%s:\n", subDir)
-			ls(subDir, recursive)
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
 		}
 	}
+	return false
 }
 
-func isHidden(file os.FileInfo) bool {
-	return file.Name()[0] == '.'
+// operand stats a command line argument. Symlinks to directories are
+// followed unless the link itself is what gets listed, i.e. with -l, -d or
+// -F; -L follows them all.
+func operand(arg string) (*fileEntry, error) {
+	info, err := os.Lstat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 && (*LFlag || !*lFlag && !*dFlag && indicatorStyle != "classify") {
+		// without -L only links to directories are followed, to list them
+		if target, err := os.Stat(arg); err == nil && (*LFlag || target.IsDir()) {
+			info = target
+		}
+	}
+	return newEntry(arg, arg, info), nil
 }
 
 func main() {
 	flag.Parse()
+
+	// like GNU ls since 9.2, --dired implies a long listing without links
+	if *diredFlag {
+		*lFlag = true
+		hyperlinkMode = "never"
+	}
+	if *nFlag || *gFlag || *oFlag {
+		*lFlag = true
+	}
+
+	if err := setTimeOptions(); err != nil {
+		fmt.Fprintf(os.Stderr, "ls: %v\n", err)
+		os.Exit(2)
+	}
+	if err := setSortKey(); err != nil {
+		fmt.Fprintf(os.Stderr, "ls: %v\n", err)
+		os.Exit(2)
+	}
+
+	if err := setSizeFormats(); err != nil {
+		fmt.Fprintf(os.Stderr, "ls: %v\n", err)
+		os.Exit(2)
+	}
+
+	switch {
+	case *indicatorFlag != "":
+		indicatorStyle = *indicatorFlag
+	case *FFlag:
+		indicatorStyle = "classify"
+	case *fileTypeFlag:
+		indicatorStyle = "file-type"
+	case *pFlag:
+		indicatorStyle = "slash"
+	}
+	switch indicatorStyle {
+	case "none", "slash", "file-type", "classify":
+	default:
+		fmt.Fprintf(os.Stderr, "ls: invalid argument '%s' for '--indicator-style'\n", indicatorStyle)
+		os.Exit(2)
+	}
+
+	setLayout()
+	setHyperlinks()
+	if err := setQuoting(); err != nil {
+		fmt.Fprintf(os.Stderr, "ls: %v\n", err)
+		os.Exit(2)
+	}
+
 	args := flag.Args()
 	if len(args) == 0 {
-		ls(".", *RFlag)
+		args = []string{"."}
+	}
+
+	var files, dirs []*fileEntry
+	for _, arg := range args {
+		e, err := operand(arg)
+		if err != nil {
+			fail(true, "cannot access '%s': %s", arg, errorText(err))
+			continue
+		}
+		if e.isDir() && !*dFlag {
+			dirs = append(dirs, e)
+		} else {
+			files = append(files, e)
+		}
+	}
+
+	// non-directory operands are listed first, as a single group
+	sortEntries(files)
+	sortEntries(dirs)
+	if len(files) > 0 {
+		printEntries(files)
+		printedBlock = true
+	}
+
+	if tree.enabled {
+		for _, dir := range dirs {
+			if printedBlock {
+				fmt.Fprintln(stdout)
+			}
+			printTree(dir)
+			printedBlock = true
+		}
+		printTreeSummary()
 	} else {
-		for _, arg := range args {
-			ls(arg, *RFlag)
+		printHeaders = len(args) > 1 || *RFlag
+		for _, dir := range dirs {
+			ls(dir.path, true, nil)
 		}
 	}
+
+	if *diredFlag {
+		printDiredOffsets()
+	}
+	stdout.Flush()
+	os.Exit(exitStatus)
 }
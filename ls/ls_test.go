@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestFormatPermissions(t *testing.T) {
+	tests := []struct {
+		mode     os.FileMode
+		expected string
+	}{
+		{0644, "-rw-r--r--"},
+		{0755 | os.ModeDir, "drwxr-xr-x"},
+		{0777 | os.ModeSymlink, "lrwxrwxrwx"},
+		{0755 | os.ModeSetuid, "-rwsr-xr-x"},
+		{0644 | os.ModeSetuid, "-rwSr--r--"},
+		{0755 | os.ModeSetgid, "-rwxr-sr-x"},
+		{0745 | os.ModeSetgid, "-rwxr-Sr-x"},
+		{0777 | os.ModeDir | os.ModeSticky, "drwxrwxrwt"},
+		{0776 | os.ModeDir | os.ModeSticky, "drwxrwxrwT"},
+		{0755 | os.ModeSetuid | os.ModeSetgid | os.ModeSticky, "-rwsr-sr-t"},
+		{0600 | os.ModeNamedPipe, "prw-------"},
+		{0660 | os.ModeDevice | os.ModeCharDevice, "crw-rw----"},
+	}
+
+	for _, test := range tests {
+		if got := formatPermissions(test.mode); got != test.expected {
+			t.Errorf("formatPermissions(%v): expected %q, got %q", test.mode, test.expected, got)
+		}
+	}
+}
+
+func TestIgnoreList(t *testing.T) {
+	rules := []string{"*.log", "!keep.log", "build/", "/root.txt", "docs/**/*.md", `\#hash`}
+	list := &ignoreList{}
+	for _, line := range rules {
+		rule, ok := parseIgnoreRule(line)
+		if !ok {
+			t.Fatalf("parseIgnoreRule(%q) rejected", line)
+		}
+		rule.base = "top"
+		list.rules = append(list.rules, rule)
+	}
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"top/a.log", false, true},
+		{"top/sub/a.log", false, true},
+		{"top/keep.log", false, false},
+		{"top/build", true, true},
+		{"top/build", false, false},
+		{"top/root.txt", false, true},
+		{"top/sub/root.txt", false, false},
+		{"top/docs/a.md", false, true},
+		{"top/docs/x/y/a.md", false, true},
+		{"top/#hash", false, true},
+		{"elsewhere/a.log", false, false},
+	}
+
+	for _, test := range tests {
+		if got := list.match(test.path, test.isDir); got != test.expected {
+			t.Errorf("match(%q, %v): expected %v, got %v", test.path, test.isDir, test.expected, got)
+		}
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		expected bool
+	}{
+		{[]string{"*.o"}, "a.o", true},
+		{[]string{"*.o"}, "a.c", false},
+		{[]string{"*"}, ".hidden", false},
+		{[]string{".*"}, ".hidden", true},
+		{[]string{""}, "a", false},
+		{[]string{""}, ".hidden", false},
+		{[]string{"", "a"}, "a", true},
+	}
+
+	for _, test := range tests {
+		if got := matchesAny(test.patterns, test.name); got != test.expected {
+			t.Errorf("matchesAny(%q, %q): expected %v, got %v", test.patterns, test.name, test.expected, got)
+		}
+	}
+}
+
+func TestQuoteName(t *testing.T) {
+	tests := []struct {
+		style    string
+		name     string
+		expected string
+	}{
+		{"literal", "a b", "a b"},
+		{"shell", "plain", "plain"},
+		{"shell", "a b", "'a b'"},
+		{"shell", "don't", `"don't"`},
+		{"shell", "it's $x", `'it'\''s $x'`},
+		{"shell", "~home", "'~home'"},
+		{"shell", "a~", "a~"},
+		{"shell-always", "plain", "'plain'"},
+		{"shell-escape", "x\ny", `'x'$'\n''y'`},
+		{"shell-escape", "\x1b[31mred", `$'\033''[31mred'`},
+		{"shell-escape", "tab\t", `'tab'$'\t'`},
+		{"c", "a\"b\n", `"a\"b\n"`},
+		{"escape", "a b\\", `a\ b\\`},
+		{"escape", "\xff", `\377`},
+	}
+
+	for _, test := range tests {
+		quotingStyle = test.style
+		if got := quoteName(test.name); got != test.expected {
+			t.Errorf("quoteName(%q) with %s: expected %s, got %s", test.name, test.style, test.expected, got)
+		}
+	}
+	quotingStyle = "literal"
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		blockSize string
+		size      int64
+		expected  string
+	}{
+		{"human-readable", 0, "0"},
+		{"human-readable", 1023, "1023"},
+		{"human-readable", 1024, "1.0K"},
+		{"human-readable", 1025, "1.1K"},
+		{"human-readable", 1536, "1.5K"},
+		{"human-readable", 10240, "10K"},
+		{"human-readable", 10241, "11K"},
+		{"human-readable", 1048575, "1.0M"},
+		{"human-readable", 23 << 20, "23M"},
+		{"si", 1000, "1.0k"},
+		{"si", 1001, "1.1k"},
+		{"1", 1536, "1536"},
+		{"K", 1536, "2K"},
+		{"KiB", 1024, "1KiB"},
+		{"KB", 1001, "2kB"},
+		{"1M", 1, "1"},
+		{"1000", 1001, "2"},
+	}
+
+	for _, test := range tests {
+		spec, err := parseBlockSize(test.blockSize)
+		if err != nil {
+			t.Fatalf("parseBlockSize(%q): %v", test.blockSize, err)
+		}
+		if got := formatSize(test.size, &spec); got != test.expected {
+			t.Errorf("formatSize(%d) with %s: expected %q, got %q", test.size, test.blockSize, test.expected, got)
+		}
+	}
+
+	for _, bad := range []string{"", "x", "0", "1X", "KiBB"} {
+		if _, err := parseBlockSize(bad); err == nil {
+			t.Errorf("parseBlockSize(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestGroupThousands(t *testing.T) {
+	tests := []struct {
+		lcAll, lcNumeric, lang string
+		expected               string
+	}{
+		{"", "", "", "146804"},
+		{"C", "", "en_US.UTF-8", "146804"},
+		{"", "POSIX", "", "146804"},
+		{"", "", "en_US.UTF-8", "146,804"},
+		{"en_US.UTF-8", "C", "", "146,804"},
+	}
+
+	for _, test := range tests {
+		t.Setenv("LC_ALL", test.lcAll)
+		t.Setenv("LC_NUMERIC", test.lcNumeric)
+		t.Setenv("LANG", test.lang)
+		if got := groupThousands("146804"); got != test.expected {
+			t.Errorf("groupThousands with LC_ALL=%q LC_NUMERIC=%q LANG=%q: expected %q, got %q",
+				test.lcAll, test.lcNumeric, test.lang, test.expected, got)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v9", "v10", -1},
+		{"v10", "v9", 1},
+		{"file2.txt", "file10.txt", -1},
+		{"a007", "a7", 0},
+		{"1.2.10", "1.2.9", 1},
+		{"abc", "abd", -1},
+		{"a", "a1", -1},
+		{"same", "same", 0},
+		{"[", "add-apt-repository", 1},
+		{"a-b", "ab", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"~", "", 1},
+		{".", "..", -1},
+		{"..", ".a", -1},
+		{".z", "a", -1},
+		{"app.tar.gz", "app-1.tar.gz", -1},
+		{"foo.1.tar.gz", "foo.10.tar.gz", -1},
+		{"foo.tar.gz", "foo.tar", 1},
+		{"python3.9", "python3.10", -1},
+	}
+
+	for _, test := range tests {
+		if got := versionCompare(test.a, test.b); got != test.expected {
+			t.Errorf("versionCompare(%q, %q): expected %d, got %d", test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func TestDecodeCapabilities(t *testing.T) {
+	tests := []struct {
+		value    []byte
+		expected string
+	}{
+		// cap_net_bind_service, effective, revision 2
+		{[]byte{1, 0, 0, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "cap_net_bind_service+ep"},
+		// cap_net_admin and cap_net_raw permitted, cap_kill inheritable
+		{[]byte{0, 0, 0, 2, 0, 0x30, 0, 0, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "cap_kill+i cap_net_admin,cap_net_raw+p"},
+		// cap_bpf in the second word, revision 3 with a root id
+		{[]byte{1, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0x80, 0, 0, 0, 0, 0, 0, 0, 0xe8, 3, 0, 0}, "cap_bpf+ep [rootid=1000]"},
+	}
+
+	for _, test := range tests {
+		got, ok := decodeCapabilities(test.value)
+		if !ok || got != test.expected {
+			t.Errorf("decodeCapabilities(%v): expected %q, got %q", test.value, test.expected, got)
+		}
+	}
+
+	if _, ok := decodeCapabilities([]byte{0, 0, 0, 2}); ok {
+		t.Errorf("decodeCapabilities: expected a truncated value to be rejected")
+	}
+}
+
+func TestOffsetVarint(t *testing.T) {
+	tests := []struct {
+		buf      []byte
+		expected int64
+		n        int
+	}{
+		{[]byte{0x00}, 0, 1},
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x80, 0x00}, 128, 2},
+		{[]byte{0x81, 0x00}, 256, 2},
+		{[]byte{0xff, 0x7f}, 16511, 2},
+		{[]byte{0x05, 0x80}, 5, 1},
+		{[]byte{0x80}, 0, 0},
+		{nil, 0, 0},
+	}
+
+	for _, test := range tests {
+		if got, n := offsetVarint(test.buf); got != test.expected || n != test.n {
+			t.Errorf("offsetVarint(%x): expected %d, %d, got %d, %d", test.buf, test.expected, test.n, got, n)
+		}
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	huge := make([]byte, binary.MaxVarintLen64)
+	huge = huge[:binary.PutUvarint(huge, 1<<62)]
+
+	tests := []struct {
+		delta    []byte
+		expected string
+		ok       bool
+	}{
+		{[]byte{11, 3, 3, 'a', 'b', 'c'}, "abc", true},
+		{[]byte{11, 5, 0x91, 6, 5}, "world", true},
+		{[]byte{11, 11, 0x90, 11}, "hello world", true},
+		{[]byte{11, 6, 0x91, 6, 5, 1, '!'}, "world!", true},
+		{[]byte{11, 0}, "", true},
+		{[]byte{10, 3, 3, 'a', 'b', 'c'}, "", false}, // wrong base size
+		{[]byte{11, 4, 3, 'a', 'b', 'c'}, "", false}, // wrong result size
+		{[]byte{11, 3, 3, 'a'}, "", false},           // truncated insert
+		{[]byte{11, 5, 0x91, 6}, "", false},          // truncated copy
+		{[]byte{11, 5, 0x91, 8, 5}, "", false},       // copy past the base
+		{[]byte{11, 0x10, 0x80}, "", false},          // 0x10000 byte copy
+		{[]byte{11, 1, 0}, "", false},                // reserved opcode
+		{[]byte{11}, "", false},                      // no result size
+		{nil, "", false},                             // no base size
+		{append([]byte{11}, huge...), "", false},     // absurd result size
+		{[]byte{0xff, 0xff, 0xff}, "", false},        // truncated varint
+	}
+
+	for _, test := range tests {
+		got, err := applyDelta(base, test.delta)
+		if (err == nil) != test.ok || string(got) != test.expected {
+			t.Errorf("applyDelta(%x): expected %q, %v, got %q, %v", test.delta, test.expected, test.ok, got, err)
+		}
+	}
+}
+
+type testIndexEntry struct {
+	path  string
+	mode  uint32
+	size  uint32
+	hash  string
+	stage int
+}
+
+// encodeIndex builds a .git/index of version 2 or 4 holding entries.
+func encodeIndex(version uint32, entries []testIndexEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString("DIRC")
+	binary.Write(&b, binary.BigEndian, version)
+	binary.Write(&b, binary.BigEndian, uint32(len(entries)))
+
+	prev := ""
+	for _, e := range entries {
+		start := b.Len()
+		fixed := make([]byte, 62)
+		binary.BigEndian.PutUint32(fixed[8:], 1700000000)
+		binary.BigEndian.PutUint32(fixed[24:], e.mode)
+		binary.BigEndian.PutUint32(fixed[36:], e.size)
+		hash, _ := hex.DecodeString(e.hash)
+		copy(fixed[40:], hash)
+		binary.BigEndian.PutUint16(fixed[60:], uint16(e.stage)<<12|uint16(len(e.path)))
+		b.Write(fixed)
+
+		if version == 4 {
+			common := 0
+			for common < len(prev) && common < len(e.path) && prev[common] == e.path[common] {
+				common++
+			}
+			b.Write(encodeOffsetVarint(uint64(len(prev) - common)))
+			b.WriteString(e.path[common:])
+			b.WriteByte(0)
+		} else {
+			b.WriteString(e.path)
+			for b.Len() == start+62+len(e.path) || (b.Len()-start)%8 != 0 {
+				b.WriteByte(0)
+			}
+		}
+		prev = e.path
+	}
+	return b.Bytes()
+}
+
+// encodeOffsetVarint is the inverse of offsetVarint.
+func encodeOffsetVarint(v uint64) []byte {
+	buf := []byte{byte(v & 0x7f)}
+	for v >>= 7; v > 0; v >>= 7 {
+		v--
+		buf = append([]byte{0x80 | byte(v&0x7f)}, buf...)
+	}
+	return buf
+}
+
+func TestReadIndex(t *testing.T) {
+	hashA := "8baef1b4abc478178b004d62031cf7fe6db6f903"
+	hashB := "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	entries := []testIndexEntry{
+		{"README", 0100644, 12, hashA, 0},
+		{"src/main.go", 0100755, 300, hashB, 0},
+		{"src/main_test.go", 0100644, 5, hashA, 0},
+		{"src/x", 0100644, 1, hashA, 1},
+		{"src/x", 0100644, 1, hashB, 3},
+	}
+
+	for _, version := range []uint32{2, 4} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "index"), encodeIndex(version, entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r := &gitRepo{gitDir: dir, hashSize: sha1.Size}
+		if err := r.readIndex(); err != nil {
+			t.Fatalf("v%d: readIndex: %v", version, err)
+		}
+
+		expected := []string{"README", "src/main.go", "src/main_test.go", "src/x"}
+		if len(r.indexKeys) != len(expected) {
+			t.Fatalf("v%d: expected paths %q, got %q", version, expected, r.indexKeys)
+		}
+		for i, path := range expected {
+			if r.indexKeys[i] != path {
+				t.Errorf("v%d: expected paths %q, got %q", version, expected, r.indexKeys)
+			}
+		}
+		if e := r.index["src/main.go"]; e.mode != 0100755 || e.size != 300 || e.hash != hashB || e.mtimeSec != 1700000000 {
+			t.Errorf("v%d: src/main.go: got %+v", version, *e)
+		}
+		// the highest stage of a conflict wins
+		if e := r.index["src/x"]; e.stage != 3 || e.hash != hashB {
+			t.Errorf("v%d: src/x: got %+v", version, *e)
+		}
+	}
+
+	for _, bad := range [][]byte{
+		[]byte("XXXX\x00\x00\x00\x02\x00\x00\x00\x00"),
+		[]byte("DIRC\x00\x00\x00\x05\x00\x00\x00\x00"),
+		encodeIndex(2, entries)[:100],
+		append(encodeIndex(4, entries[:1])[:74], 0x7f, 'x', 0),
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "index"), bad, 0644); err != nil {
+			t.Fatal(err)
+		}
+		r := &gitRepo{gitDir: dir, hashSize: sha1.Size}
+		if err := r.readIndex(); err == nil {
+			t.Errorf("readIndex(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestDirStatus(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"src/a.go":       "a",
+		"src/b.go":       "changed",
+		"new/c.go":       "c",
+		"clean/d.go":     "d",
+		"clean/e.txt":    "untracked",
+		"untracked/f.go": "f",
+	}
+	for path, content := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &gitRepo{root: root, gitDir: filepath.Join(root, ".git"), hashSize: sha1.Size}
+	blob := func(content string) string { return r.blobHash([]byte(content)) }
+	r.index = map[string]*indexEntry{
+		"src/a.go":   {mode: 0100644, hash: blob("a")},
+		"src/b.go":   {mode: 0100644, hash: blob("b")},
+		"new/c.go":   {mode: 0100644, hash: blob("c")},
+		"clean/d.go": {mode: 0100644, hash: blob("d")},
+	}
+	r.head = map[string]treeEntry{
+		"src/a.go":   {mode: 0100644, hash: blob("a")},
+		"src/b.go":   {mode: 0100644, hash: blob("b")},
+		"clean/d.go": {mode: 0100644, hash: blob("d")},
+		"gone/x.go":  {mode: 0100644, hash: blob("x")},
+	}
+	for path := range r.index {
+		r.indexKeys = append(r.indexKeys, path)
+	}
+	sort.Strings(r.indexKeys)
+	r.init()
+
+	tests := []struct {
+		dir      string
+		expected string
+	}{
+		{"src", "-M"},
+		{"new", "A-"},
+		{"clean", "-?"},
+		{"gone", "D-"},
+		{"untracked", "??"},
+		{".", "DM"},
+	}
+
+	for _, test := range tests {
+		if got := r.dirStatus(test.dir); got != test.expected {
+			t.Errorf("dirStatus(%q): expected %q, got %q", test.dir, test.expected, got)
+		}
+	}
+}
+
+func TestCommitTreeBadTag(t *testing.T) {
+	gitDir := t.TempDir()
+	r := &gitRepo{gitDir: gitDir, hashSize: sha1.Size}
+
+	tag := "tag 19\x00object a\ntype commit\n"
+	sum := sha1.Sum([]byte(tag))
+	name := hex.EncodeToString(sum[:])
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write([]byte(tag))
+	zw.Close()
+	dir := filepath.Join(gitDir, "objects", name[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name[2:]), b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.commitTree(name); err == nil {
+		t.Errorf("commitTree of a tag pointing at %q: expected an error", "a")
+	}
+}
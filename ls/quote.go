@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var quotingStyles = []string{"literal", "shell", "shell-always", "shell-escape", "shell-escape-always", "c", "escape"}
+
+var (
+	quotingStyle = "literal"
+	hideControl  bool
+
+	// padUnquoted shifts names that need no quotes by one column when
+	// others in the same listing start with a quote, so names line up
+	padUnquoted bool
+)
+
+// setQuoting resolves the quoting options the way GNU ls does: the last
+// explicit style wins, then QUOTING_STYLE, then shell-escape on a terminal.
+func setQuoting() error {
+	tty := isTerminal(os.Stdout)
+	if tty {
+		quotingStyle = "shell-escape"
+	}
+	if env := os.Getenv("QUOTING_STYLE"); env != "" && validQuotingStyle(env) {
+		quotingStyle = env
+	}
+
+	switch {
+	case *quotingStyleFlag != "":
+		if !validQuotingStyle(*quotingStyleFlag) {
+			return fmt.Errorf("invalid argument '%s' for '--quoting-style'", *quotingStyleFlag)
+		}
+		quotingStyle = *quotingStyleFlag
+	case *bFlag:
+		quotingStyle = "escape"
+	case *QFlag:
+		quotingStyle = "c"
+	case *NFlag:
+		quotingStyle = "literal"
+	}
+
+	hideControl = (tty || *qFlag) && !*showControlFlag
+	return nil
+}
+
+func validQuotingStyle(style string) bool {
+	for _, s := range quotingStyles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+// alignsQuotes reports whether the current format lines names up in columns.
+func alignsQuotes() bool {
+	if !*lFlag && layout == layoutOnePerLine {
+		return false
+	}
+	switch quotingStyle {
+	case "shell", "shell-escape":
+		return true
+	}
+	return false
+}
+
+func setPadUnquoted(entries []*fileEntry) {
+	padUnquoted = false
+	if !alignsQuotes() {
+		return
+	}
+	for _, e := range entries {
+		if quoteName(e.name) != e.name {
+			padUnquoted = true
+			return
+		}
+	}
+}
+
+// entryName quotes the name of a listed entry, padding it if needed.
+func entryName(e *fileEntry) string {
+	return namePad(e) + linkName(e.path, quoteName(e.name))
+}
+
+func namePad(e *fileEntry) string {
+	if padUnquoted && quoteName(e.name) == e.name {
+		return " "
+	}
+	return ""
+}
+
+// quoteName renders name in the selected quoting style.
+func quoteName(name string) string {
+	switch quotingStyle {
+	case "shell":
+		return quoteShell(name, false, false)
+	case "shell-always":
+		return quoteShell(name, true, false)
+	case "shell-escape":
+		return quoteShell(name, false, true)
+	case "shell-escape-always":
+		return quoteShell(name, true, true)
+	case "c":
+		return `"` + escapeC(name, false) + `"`
+	case "escape":
+		return escapeC(name, true)
+	}
+	return hideControlChars(name)
+}
+
+func hideControlChars(name string) string {
+	if !hideControl {
+		return name
+	}
+	var b strings.Builder
+	forEachChar(name, func(r rune, raw string, printable bool) {
+		if printable {
+			b.WriteString(raw)
+		} else {
+			b.WriteByte('?')
+		}
+	})
+	return b.String()
+}
+
+// forEachChar walks name rune by rune, handing invalid UTF-8 over one byte
+// at a time as a non-printable character.
+func forEachChar(name string, fn func(r rune, raw string, printable bool)) {
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		printable := unicode.IsPrint(r) && !(r == utf8.RuneError && size == 1)
+		fn(r, name[i:i+size], printable)
+		i += size
+	}
+}
+
+// needsShellQuote mirrors the set of characters gnulib's quotearg considers
+// special to the shell.
+func needsShellQuote(name string) bool {
+	if name == "" || name == "{" || name == "}" {
+		return true
+	}
+	if name[0] == '#' || name[0] == '~' {
+		return true
+	}
+	quote := false
+	forEachChar(name, func(r rune, raw string, printable bool) {
+		if !printable || strings.ContainsRune(" \t\n!\"$&'()*;<=>?[\\]^`|", r) {
+			quote = true
+		}
+	})
+	return quote
+}
+
+func quoteShell(name string, always, escape bool) string {
+	if !always && !needsShellQuote(name) {
+		return name
+	}
+
+	hasControl := false
+	forEachChar(name, func(r rune, raw string, printable bool) {
+		if !printable {
+			hasControl = true
+		}
+	})
+
+	// a lone quote reads better wrapped in double quotes, as long as
+	// nothing else would need escaping inside them
+	if strings.Contains(name, "'") && !hasControl && !strings.ContainsAny(name, "\"$`\\!") {
+		return `"` + name + `"`
+	}
+
+	var b strings.Builder
+	b.WriteByte('\'')
+	forEachChar(name, func(r rune, raw string, printable bool) {
+		switch {
+		case r == '\'':
+			b.WriteString(`'\''`)
+		case !printable && escape:
+			b.WriteString(`'$'` + escapeC(raw, false) + `''`)
+		case !printable && hideControl:
+			b.WriteByte('?')
+		default:
+			b.WriteString(raw)
+		}
+	})
+	b.WriteByte('\'')
+
+	// drop the empty '' left around leading and trailing escapes
+	q := b.String()
+	if len(q) > 2 && strings.HasPrefix(q, "''") {
+		q = q[2:]
+	}
+	if len(q) > 2 && strings.HasSuffix(q, "''") {
+		q = q[:len(q)-2]
+	}
+	return q
+}
+
+var cEscapes = map[rune]string{
+	'\a': `\a`, '\b': `\b`, '\f': `\f`, '\n': `\n`,
+	'\r': `\r`, '\t': `\t`, '\v': `\v`, '\\': `\\`,
+}
+
+// escapeC backslash-escapes name like a C string. The escape style also
+// escapes spaces instead of relying on surrounding quotes.
+func escapeC(name string, escapeSpace bool) string {
+	var b strings.Builder
+	forEachChar(name, func(r rune, raw string, printable bool) {
+		switch {
+		case cEscapes[r] != "" && len(raw) == 1:
+			b.WriteString(cEscapes[r])
+		case r == '"' && !escapeSpace:
+			b.WriteString(`\"`)
+		case r == ' ' && escapeSpace:
+			b.WriteString(`\ `)
+		case printable:
+			b.WriteString(raw)
+		default:
+			for i := 0; i < len(raw); i++ {
+				fmt.Fprintf(&b, "\\%03o", raw[i])
+			}
+		}
+	})
+	return b.String()
+}
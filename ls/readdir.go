@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	readBatch   = 4096 // directory entries fetched per getdents64 round
+	statWorkers = 32
+)
+
+// needStat reports whether the requested output uses anything beyond the
+// names and d_type of the directory entries.
+func needStat() bool {
+	return *lFlag || *sFlag || *iFlag || sortKey == "time" || sortKey == "size" || *LFlag || indicatorStyle == "classify"
+}
+
+// streaming reports whether entries can be printed as they are read, which
+// keeps memory flat on huge directories.
+func streaming() bool {
+	return sortKey == "none" && !needStat() && layout == layoutOnePerLine && !tree.enabled
+}
+
+// readDirectory reads dirname in batches. Entries are not stat'ed. When
+// streaming, each batch is printed right away and only the directories are
+// kept.
+func readDirectory(dirname string, commandLine bool, ignores *ignoreList) []*fileEntry {
+	var entries []*fileEntry
+	if *aFlag {
+		for _, name := range []string{".", ".."} {
+			path := filepath.Join(dirname, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				fail(false, "cannot access '%s': %s", path, errorText(err))
+				continue
+			}
+			if e := newEntry(name, path, info); listed(e, ignores) {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	file, err := os.Open(dirname)
+	if err != nil {
+		fail(commandLine, "cannot open directory '%s': %s", dirname, errorText(err))
+		return nil
+	}
+	defer file.Close()
+
+	var dirs []*fileEntry
+	for {
+		dirents, err := file.ReadDir(readBatch)
+		for _, d := range dirents {
+			e := &fileEntry{
+				name: d.Name(),
+				path: filepath.Join(dirname, d.Name()),
+				typ:  d.Type(),
+			}
+			if listed(e, ignores) {
+				entries = append(entries, e)
+			}
+		}
+
+		if streaming() {
+			printEntries(entries)
+			for _, e := range entries {
+				if e.isDir() {
+					dirs = append(dirs, e)
+				}
+			}
+			entries = entries[:0]
+		}
+
+		if err == io.EOF {
+			break
+		}
+		// keep whatever was read before a failure, like GNU ls does
+		if err != nil {
+			fail(false, "reading directory '%s': %s", dirname, errorText(err))
+			break
+		}
+	}
+
+	if streaming() {
+		return dirs
+	}
+	return entries
+}
+
+// statEntries fills in the metadata of entries using a bounded pool of
+// workers, dropping the ones that vanished since they were read.
+func statEntries(entries []*fileEntry) []*fileEntry {
+	errs := make([]error, len(entries))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < statWorkers && w < len(entries); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = statEntry(entries[i])
+			}
+		}()
+	}
+	for i, e := range entries {
+		if e.info == nil {
+			indexes <- i
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	kept := entries[:0]
+	for i, e := range entries {
+		if errs[i] != nil {
+			fail(false, "cannot access '%s': %s", e.path, errorText(errs[i]))
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+func statEntry(e *fileEntry) error {
+	info, err := os.Lstat(e.path)
+	if err != nil {
+		return err
+	}
+	if *LFlag && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Stat(e.path); err == nil {
+			info = target
+		}
+	}
+	e.info = info
+	e.typ = info.Mode().Type()
+	if timeField == "birth" {
+		e.readBirth()
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sizeSpec says how a byte count is printed, as parsed from --block-size.
+type sizeSpec struct {
+	unit     uint64
+	human    bool   // pick the unit per value, like -h and --si
+	base     uint64 // 1024 or 1000
+	suffix   string // appended to scaled values, e.g. "M" for --block-size=M
+	grouping bool   // the ' prefix, group digits in thousands
+}
+
+var (
+	sizeFormat  = sizeSpec{unit: 1}    // the size column
+	blockFormat = sizeSpec{unit: 1024} // -s and the total line
+)
+
+// setSizeFormats applies the environment and the size options, the later
+// ones taking precedence: LS_BLOCK_SIZE or BLOCK_SIZE, -k, --block-size,
+// then -h and --si.
+func setSizeFormats() error {
+	for _, env := range []string{"LS_BLOCK_SIZE", "BLOCK_SIZE"} {
+		if v := os.Getenv(env); v != "" {
+			if spec, err := parseBlockSize(v); err == nil {
+				sizeFormat, blockFormat = spec, spec
+			}
+			break
+		}
+	}
+
+	if *kFlag {
+		blockFormat = sizeSpec{unit: 1024}
+	}
+
+	if *blockSizeFlag != "" {
+		spec, err := parseBlockSize(*blockSizeFlag)
+		if err != nil {
+			return err
+		}
+		sizeFormat, blockFormat = spec, spec
+	}
+
+	switch {
+	case *hFlag:
+		sizeFormat = sizeSpec{unit: 1, human: true, base: 1024}
+		blockFormat = sizeFormat
+	case *siFlag:
+		sizeFormat = sizeSpec{unit: 1, human: true, base: 1000}
+		blockFormat = sizeFormat
+	}
+	return nil
+}
+
+// parseBlockSize understands the SIZE forms of GNU ls: an integer with an
+// optional unit (K, KiB = 1024; KB = 1000; likewise M, G, T, P, E, Z, Y),
+// "human-readable", "si", and a leading ' for digit grouping. A unit
+// without a number is also printed after the values.
+func parseBlockSize(s string) (sizeSpec, error) {
+	spec := sizeSpec{base: 1024}
+	arg := s
+
+	if strings.HasPrefix(s, "'") {
+		spec.grouping = true
+		s = s[1:]
+	}
+
+	switch s {
+	case "human-readable":
+		spec.unit, spec.human = 1, true
+		return spec, nil
+	case "si":
+		spec.unit, spec.human, spec.base = 1, true, 1000
+		return spec, nil
+	}
+
+	digits := strings.TrimRight(s, "KkMGTPEZYiB")
+	suffix := s[len(digits):]
+
+	n := uint64(1)
+	if digits != "" {
+		var err error
+		n, err = strconv.ParseUint(digits, 10, 64)
+		if err != nil || n == 0 {
+			return spec, fmt.Errorf("invalid --block-size argument '%s'", arg)
+		}
+	}
+
+	if suffix == "" {
+		if digits == "" {
+			return spec, fmt.Errorf("invalid --block-size argument '%s'", arg)
+		}
+		spec.unit = n
+		return spec, nil
+	}
+
+	exp := strings.IndexByte("KMGTPEZY", suffix[0])
+	if suffix[0] == 'k' {
+		exp = 0
+	}
+	if exp < 0 {
+		return spec, fmt.Errorf("invalid suffix in --block-size argument '%s'", arg)
+	}
+
+	switch suffix[1:] {
+	case "", "iB":
+	case "B":
+		spec.base = 1000
+	default:
+		return spec, fmt.Errorf("invalid suffix in --block-size argument '%s'", arg)
+	}
+
+	for i := 0; i <= exp; i++ {
+		if n > ^uint64(0)/spec.base {
+			return spec, fmt.Errorf("--block-size argument '%s' too large", arg)
+		}
+		n *= spec.base
+	}
+	spec.unit = n
+
+	if digits == "" {
+		spec.suffix = suffix
+		if spec.base == 1000 && suffix[0] == 'K' {
+			spec.suffix = "k" + suffix[1:]
+		}
+	}
+	return spec, nil
+}
+
+func formatSize(size int64, spec *sizeSpec) string {
+	if size < 0 {
+		size = 0
+	}
+	n := uint64(size)
+
+	if spec.human {
+		units := "KMGTPEZY"
+		if spec.base == 1000 {
+			units = "kMGTPEZY"
+		}
+		return humanSize(n, spec.base, units)
+	}
+
+	// like GNU, partial units are rounded up
+	value := n / spec.unit
+	if n%spec.unit != 0 {
+		value++
+	}
+	digits := strconv.FormatUint(value, 10)
+	if spec.grouping {
+		digits = groupThousands(digits)
+	}
+	return digits + spec.suffix
+}
+
+// humanSize scales n to the largest unit that keeps it below base and rounds
+// up the way GNU does: one decimal below 10, whole numbers above.
+func humanSize(n, base uint64, units string) string {
+	if n < base {
+		return strconv.FormatUint(n, 10)
+	}
+
+	div, exp := uint64(1), -1
+	for n/div >= base && exp < len(units)-1 {
+		div *= base
+		exp++
+	}
+
+	q, r := n/div, n%div
+	if q < 10 {
+		tenths := q*10 + (r*10+div-1)/div
+		if tenths < 100 {
+			return fmt.Sprintf("%d.%d%c", tenths/10, tenths%10, units[exp])
+		}
+		q, r = 10, 0
+	}
+	if r != 0 {
+		q++
+	}
+	if q >= base && exp < len(units)-1 {
+		return fmt.Sprintf("1.0%c", units[exp+1])
+	}
+	return fmt.Sprintf("%d%c", q, units[exp])
+}
+
+// groupThousands inserts the locale's thousands separator. The C locale
+// has none, anything else gets a comma.
+func groupThousands(digits string) string {
+	if !groupingLocale() {
+		return digits
+	}
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// groupingLocale reports whether the locale in effect, the first of
+// LC_ALL, LC_NUMERIC and LANG set, is other than C. Nothing set means C.
+func groupingLocale() bool {
+	for _, env := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			return v != "C" && v != "POSIX"
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+)
+
+var sortKeys = []string{"name", "none", "size", "time", "version", "extension", "width"}
+
+var sortKey = "name"
+
+// setSortKey resolves --sort and its short forms. As in GNU ls, -u and -c
+// also sort by their time unless the listing is long.
+func setSortKey() error {
+	switch {
+	case *sortFlag != "":
+		for _, key := range sortKeys {
+			if key == *sortFlag {
+				sortKey = key
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid argument '%s' for '--sort'", *sortFlag)
+	case *UFlag:
+		sortKey = "none"
+	case *SFlag:
+		sortKey = "size"
+	case *tFlag:
+		sortKey = "time"
+	case *XFlag:
+		sortKey = "extension"
+	case *vFlag:
+		sortKey = "version"
+	case !*lFlag && timeField != "mtime":
+		sortKey = "time"
+	}
+	return nil
+}
+
+func sortEntries(entries []*fileEntry) {
+	if sortKey == "none" {
+		return
+	}
+
+	var dirs []bool
+	if *dirsFirstFlag {
+		dirs = make([]bool, len(entries))
+		for i, e := range entries {
+			dirs[i] = sortsAsDir(e)
+		}
+	}
+
+	// sort an index so the directory flags follow their entries
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		x, y := order[i], order[j]
+		// grouping is not affected by -r
+		if dirs != nil && dirs[x] != dirs[y] {
+			return dirs[x]
+		}
+		if *rFlag {
+			x, y = y, x
+		}
+		return entryLess(entries[x], entries[y])
+	})
+
+	sorted := make([]*fileEntry, len(entries))
+	for i, x := range order {
+		sorted[i] = entries[x]
+	}
+	copy(entries, sorted)
+}
+
+// sortsAsDir reports whether e is a directory or a symlink to one.
+func sortsAsDir(e *fileEntry) bool {
+	if e.typ&os.ModeSymlink != 0 {
+		info, err := os.Stat(e.path)
+		return err == nil && info.IsDir()
+	}
+	return e.isDir()
+}
+
+func entryLess(a, b *fileEntry) bool {
+	switch sortKey {
+	case "time":
+		ta, _ := a.time()
+		tb, _ := b.time()
+		if !ta.Equal(tb) {
+			return ta.After(tb)
+		}
+	case "size":
+		if a.info.Size() != b.info.Size() {
+			return a.info.Size() > b.info.Size()
+		}
+	case "extension":
+		ea, eb := filepath.Ext(a.name), filepath.Ext(b.name)
+		if ea != eb {
+			return ea < eb
+		}
+	case "width":
+		wa := utf8.RuneCountInString(quoteName(a.name) + indicator(a.mode()))
+		wb := utf8.RuneCountInString(quoteName(b.name) + indicator(b.mode()))
+		if wa != wb {
+			return wa < wb
+		}
+	case "version":
+		if c := versionCompare(a.name, b.name); c != 0 {
+			return c < 0
+		}
+	}
+	return a.name < b.name
+}
+
+// versionCompare orders names the way GNU filevercmp does: runs of digits
+// compare by their numeric value, '~' sorts before anything, letters before
+// other characters, and file suffixes like ".tar.gz" only break ties.
+func versionCompare(a, b string) int {
+	switch {
+	case a == "" || b == "":
+		return compareInts(len(a), len(b))
+	case a[0] == '.' && b[0] != '.':
+		return -1
+	case a[0] != '.' && b[0] == '.':
+		return 1
+	case a[0] == '.':
+		// ".", then "..", then the other dot files
+		for _, special := range []string{".", ".."} {
+			if a == special || b == special {
+				return compareInts(boolInt(b == special), boolInt(a == special))
+			}
+		}
+	}
+
+	ap, bp := prefixLen(a), prefixLen(b)
+	if c := verrevcmp(a[:ap], b[:bp]); c != 0 || ap == len(a) && bp == len(b) {
+		return c
+	}
+	return verrevcmp(a, b)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// prefixLen returns the length of s without its file suffix, the longest
+// match of (\.[A-Za-z~][A-Za-z0-9~]*)*$ that does not take all of s.
+func prefixLen(s string) int {
+	prefix := 0
+	for i := 0; i < len(s); {
+		i++
+		prefix = i
+		for i+1 < len(s) && s[i] == '.' && (isAlpha(s[i+1]) || s[i+1] == '~') {
+			for i += 2; i < len(s) && (isAlpha(s[i]) || isDigit(s[i]) || s[i] == '~'); i++ {
+			}
+		}
+	}
+	return prefix
+}
+
+// verrevcmp is the Debian version comparison filevercmp builds on.
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isDigit(a[i]) || j < len(b) && !isDigit(b[j]) {
+			ca, cb := 0, 0
+			if i < len(a) {
+				ca = versionOrder(a[i])
+			}
+			if j < len(b) {
+				cb = versionOrder(b[j])
+			}
+			if ca != cb {
+				return compareInts(ca, cb)
+			}
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && j < len(b) && isDigit(a[i]) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = compareInts(int(a[i]), int(b[j]))
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+	return 0
+}
+
+// versionOrder ranks the characters outside digit runs: '~' first, then the
+// end of the string, letters, and everything else.
+func versionOrder(c byte) int {
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
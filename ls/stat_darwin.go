@@ -0,0 +1,30 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func accessTime(st *syscall.Stat_t) time.Time {
+	return time.Unix(st.Atimespec.Unix())
+}
+
+func mtimeOf(st *syscall.Stat_t) (sec, nsec int64) {
+	return st.Mtimespec.Unix()
+}
+
+func changeTime(st *syscall.Stat_t) time.Time {
+	return time.Unix(st.Ctimespec.Unix())
+}
+
+func birthTime(path string, follow bool) (time.Time, bool) {
+	var st syscall.Stat_t
+	stat := syscall.Lstat
+	if follow {
+		stat = syscall.Stat
+	}
+	if err := stat(path, &st); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Birthtimespec.Unix()), true
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func accessTime(st *syscall.Stat_t) time.Time {
+	return time.Unix(st.Atim.Unix())
+}
+
+func mtimeOf(st *syscall.Stat_t) (sec, nsec int64) {
+	return st.Mtim.Unix()
+}
+
+func changeTime(st *syscall.Stat_t) time.Time {
+	return time.Unix(st.Ctim.Unix())
+}
+
+// birthTime asks statx(2) for the creation time, which not every
+// filesystem records.
+func birthTime(path string, follow bool) (time.Time, bool) {
+	flags := unix.AT_SYMLINK_NOFOLLOW
+	if follow {
+		flags = 0
+	}
+
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, flags, unix.STATX_BTIME, &stx); err != nil {
+		return time.Time{}, false
+	}
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec)), true
+}
@@ -0,0 +1,7 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// ioctlGetTermios reads the terminal attributes, failing on anything but a
+// terminal.
+const ioctlGetTermios = unix.TIOCGETA
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// sixMonths is GNU's cut-off between "recent" timestamps, shown with the
+// time of day, and older ones, shown with the year.
+const sixMonths = 31556952 / 2 * time.Second
+
+var timeField = "mtime"
+
+// setTimeOptions resolves -u, -c, --time and --full-time.
+func setTimeOptions() error {
+	switch *timeFlag {
+	case "":
+	case "mtime", "modification":
+		timeField = "mtime"
+	case "atime", "access", "use":
+		timeField = "atime"
+	case "ctime", "status":
+		timeField = "ctime"
+	case "birth", "creation":
+		timeField = "birth"
+	default:
+		return fmt.Errorf("invalid argument '%s' for '--time'", *timeFlag)
+	}
+	if *timeFlag == "" {
+		switch {
+		case *cFlag:
+			timeField = "ctime"
+		case *uFlag:
+			timeField = "atime"
+		}
+	}
+
+	if *fullTimeFlag {
+		*lFlag = true
+	}
+	return nil
+}
+
+// time returns the timestamp selected by --time. ok is false when the
+// filesystem does not record it, which only happens for birth times.
+func (e *fileEntry) time() (t time.Time, ok bool) {
+	switch timeField {
+	case "atime":
+		return accessTime(e.sys()), true
+	case "ctime":
+		return changeTime(e.sys()), true
+	case "birth":
+		e.readBirth()
+		if e.birth == nil {
+			return time.Time{}, false
+		}
+		return *e.birth, true
+	}
+	return e.info.ModTime(), true
+}
+
+func (e *fileEntry) readBirth() {
+	if e.birthRead {
+		return
+	}
+	e.birthRead = true
+	if t, ok := birthTime(e.path, *LFlag); ok {
+		e.birth = &t
+	}
+}
+
+func formatTime(t time.Time, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	if *fullTimeFlag {
+		return t.Format("2006-01-02 15:04:05.000000000 -0700")
+	}
+
+	now := time.Now()
+	if t.After(now.Add(-sixMonths)) && !t.After(now.Add(time.Hour)) {
+		return t.Format("Jan _2 15:04")
+	}
+	return t.Format("Jan _2  2006")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// treeOption is the value of --tree[=DEPTH]. It behaves as a boolean flag
+// so that a bare --tree is accepted.
+type treeOption struct {
+	enabled bool
+	depth   int // 0 means unlimited
+}
+
+func (t *treeOption) IsBoolFlag() bool {
+	return true
+}
+
+func (t *treeOption) String() string {
+	if t == nil || !t.enabled {
+		return ""
+	}
+	return strconv.Itoa(t.depth)
+}
+
+func (t *treeOption) Set(s string) error {
+	switch s {
+	case "true":
+		t.enabled = true
+	case "false":
+		t.enabled = false
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid depth '%s'", s)
+		}
+		t.enabled, t.depth = true, n
+	}
+	return nil
+}
+
+var tree treeOption
+
+var treeDirs, treeFiles int
+
+type treeRow struct {
+	entry  *fileEntry
+	prefix string
+}
+
+// printTree walks root with the usual filters and sort order and draws the
+// result with box-drawing connectors. With -l the long format columns are
+// aligned over the whole tree.
+func printTree(root *fileEntry) {
+	rows := []treeRow{{entry: root}}
+
+	var walk func(dir *fileEntry, prefix string, depth int, ignores *ignoreList)
+	walk = func(dir *fileEntry, prefix string, depth int, ignores *ignoreList) {
+		id, err := dirIDOf(dir.path)
+		if err != nil {
+			fail(depth == 1, "cannot access '%s': %s", dir.path, errorText(err))
+			return
+		}
+		if activeDirs[id] {
+			fail(true, "%s: not listing already-listed directory", dir.path)
+			return
+		}
+		activeDirs[id] = true
+		defer delete(activeDirs, id)
+
+		ignores = loadIgnoreFiles(ignores, dir.path)
+
+		var entries []*fileEntry
+		for _, e := range readDirectory(dir.path, depth == 1, ignores) {
+			if e.name != "." && e.name != ".." {
+				entries = append(entries, e)
+			}
+		}
+		if needStat() {
+			entries = statEntries(entries)
+		}
+		sortEntries(entries)
+
+		for i, e := range entries {
+			connector, indent := "├── ", "│   "
+			if i == len(entries)-1 {
+				connector, indent = "└── ", "    "
+			}
+			rows = append(rows, treeRow{entry: e, prefix: prefix + connector})
+
+			if !e.isDir() {
+				treeFiles++
+				continue
+			}
+			treeDirs++
+			if tree.depth == 0 || depth < tree.depth {
+				walk(e, prefix+indent, depth+1, ignores)
+			}
+		}
+	}
+	walk(root, "", 1, nil)
+
+	entries := make([]*fileEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = row.entry
+	}
+	setPadUnquoted(entries)
+
+	if *lFlag {
+		fields := longFields(entries)
+		for i, row := range rows {
+			printLongLine(fields[i]+row.prefix, row.entry)
+		}
+		return
+	}
+	for _, row := range rows {
+		fmt.Fprintln(stdout, row.prefix+formatFile(row.entry))
+	}
+}
+
+func printTreeSummary() {
+	dirs, files := "directories", "files"
+	if treeDirs == 1 {
+		dirs = "directory"
+	}
+	if treeFiles == 1 {
+		files = "file"
+	}
+	fmt.Fprintf(stdout, "\n%d %s, %d %s\n", treeDirs, dirs, treeFiles, files)
+}
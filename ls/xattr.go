@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrSELinux    = "security.selinux"
+	xattrCapability = "security.capability"
+)
+
+// lgetxattr returns the value of the named extended attribute of path
+// without following symlinks, growing the buffer until the value fits.
+func lgetxattr(path, name string) ([]byte, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Lgetxattr(path, name, buf)
+		if err == unix.ERANGE {
+			size, err := unix.Lgetxattr(path, name, nil)
+			if err != nil {
+				return nil, err
+			}
+			buf = make([]byte, size)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// llistxattr returns the names of the extended attributes of path, without
+// following symlinks.
+func llistxattr(path string) ([]string, error) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := unix.Llistxattr(path, buf)
+		if err == unix.ERANGE {
+			size, err := unix.Llistxattr(path, nil)
+			if err != nil {
+				return nil, err
+			}
+			buf = make([]byte, size)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// printXattrs lists the extended attributes of e beneath its long format
+// line, one per line with its size, decoding file capabilities.
+func printXattrs(e *fileEntry) {
+	names, err := llistxattr(e.path)
+	if err != nil {
+		if err != unix.ENOTSUP && err != unix.ENODATA {
+			fail(false, "%s: cannot list extended attributes: %s", e.path, errorText(err))
+		}
+		return
+	}
+
+	for _, name := range names {
+		value, err := lgetxattr(e.path, name)
+		if err != nil {
+			continue
+		}
+		if *diredFlag {
+			stdout.WriteString(diredIndent)
+		}
+		fmt.Fprintf(stdout, "\t%s\t%4s", quoteName(name), formatSize(int64(len(value)), &sizeFormat))
+		if name == xattrCapability {
+			if caps, ok := decodeCapabilities(value); ok {
+				stdout.WriteString(" " + caps)
+			}
+		}
+		stdout.WriteString("\n")
+	}
+}
+
+// capabilityNames is indexed by capability number, as in linux/capability.h.
+var capabilityNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner",
+	"cap_fsetid", "cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap",
+	"cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast",
+	"cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner",
+	"cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+	"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod",
+	"cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm",
+	"cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+const (
+	vfsCapRevisionMask = 0xff000000
+	vfsCapRevision1    = 0x01000000
+	vfsCapRevision2    = 0x02000000
+	vfsCapRevision3    = 0x03000000
+	vfsCapEffective    = 0x000001
+)
+
+// decodeCapabilities renders a security.capability value (struct
+// vfs_cap_data) the way getcap does, grouping capabilities that share the
+// same flags: "cap_net_admin,cap_net_raw+ep cap_kill+i".
+func decodeCapabilities(value []byte) (string, bool) {
+	if len(value) < 4 {
+		return "", false
+	}
+	magic := binary.LittleEndian.Uint32(value)
+
+	words, size := 0, 0
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		words, size = 1, 12
+	case vfsCapRevision2:
+		words, size = 2, 20
+	case vfsCapRevision3:
+		words, size = 2, 24
+	default:
+		return "", false
+	}
+	if len(value) < size {
+		return "", false
+	}
+
+	// each word holds a permitted and an inheritable mask
+	var permitted, inheritable uint64
+	for i := 0; i < words; i++ {
+		permitted |= uint64(binary.LittleEndian.Uint32(value[4+8*i:])) << (32 * i)
+		inheritable |= uint64(binary.LittleEndian.Uint32(value[8+8*i:])) << (32 * i)
+	}
+	effective := magic&vfsCapEffective != 0
+
+	var order []string
+	groups := make(map[string][]string)
+	for c := 0; c < 64; c++ {
+		bit := uint64(1) << c
+		p, i := permitted&bit != 0, inheritable&bit != 0
+		if !p && !i {
+			continue
+		}
+		flags := ""
+		if effective {
+			flags += "e"
+		}
+		if i {
+			flags += "i"
+		}
+		if p {
+			flags += "p"
+		}
+		if groups[flags] == nil {
+			order = append(order, flags)
+		}
+		groups[flags] = append(groups[flags], capabilityName(c))
+	}
+	if len(order) == 0 {
+		return "", false
+	}
+
+	var parts []string
+	for _, flags := range order {
+		parts = append(parts, strings.Join(groups[flags], ",")+"+"+flags)
+	}
+	caps := strings.Join(parts, " ")
+	if magic&vfsCapRevisionMask == vfsCapRevision3 {
+		if rootid := binary.LittleEndian.Uint32(value[20:]); rootid != 0 {
+			caps += " [rootid=" + strconv.FormatUint(uint64(rootid), 10) + "]"
+		}
+	}
+	return caps, true
+}
+
+func capabilityName(c int) string {
+	if c < len(capabilityNames) {
+		return capabilityNames[c]
+	}
+	return strconv.Itoa(c)
+}
+
+// hasACL reports whether path carries a POSIX access ACL.
+func hasACL(path string) bool {
+	_, err := unix.Lgetxattr(path, xattrACLAccess, nil)
+	return err == nil
+}
+
+// securityContext returns the SELinux context of path, or "" if it has none.
+func securityContext(path string) string {
+	value, err := lgetxattr(path, xattrSELinux)
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimRight(value, "\x00"))
+}
+
+// aclIndicator returns the character GNU ls prints after the mode string:
+// '+' when the file has an ACL, '.' when it only has a security context and
+// ' ' otherwise.
+func aclIndicator(path string) byte {
+	if hasACL(path) {
+		return '+'
+	}
+	if securityContext(path) != "" {
+		return '.'
+	}
+	return ' '
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// compression names the format file is compressed with, going by the magic
+// number at its start, or returns "" for anything else, which is tailed as
+// it is. The file position is left alone.
+func compression(file *os.File) string {
+	var magic [10]byte
+	n, _ := file.ReadAt(magic[:], 0)
+	switch {
+	case n >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		// two bytes are little proof, so the whole header has to parse
+		if _, err := gzip.NewReader(io.NewSectionReader(file, 0, 1<<62)); err == nil {
+			return "gzip"
+		}
+	case n == 10 && string(magic[:3]) == "BZh" && magic[3] >= '1' && magic[3] <= '9':
+		// the header is followed by a block or the end of the stream
+		switch string(magic[4:]) {
+		case "\x31\x41\x59\x26\x53\x59", "\x17\x72\x45\x38\x50\x90":
+			return "bzip2"
+		}
+	}
+	return ""
+}
+
+// decompress returns a reader of the content of file, which is compressed
+// in format. Decompressed content cannot be seeked, so it is tailed like a
+// stream.
+func decompress(file *os.File, format string) (io.Reader, error) {
+	r := bufio.NewReader(file)
+	if format == "bzip2" {
+		return bzip2.NewReader(r), nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	matchRE, excludeRE, highlightRE *regexp.Regexp
+
+	// colorize is set when stdout is a terminal, the only place
+	// --highlight escapes belong
+	colorize bool
+)
+
+const (
+	highlightStart = "\x1b[01;31m"
+	highlightEnd   = "\x1b[m"
+)
+
+func filtering() bool {
+	return matchRE != nil || excludeRE != nil || highlightRE != nil && colorize
+}
+
+// isTerminal reports whether f is a terminal; other character devices, like
+// /dev/null, are not.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlGetTermios)
+	return err == nil
+}
+
+// sink returns where the data followed in name goes: straight to its place
+// in the output, or through a lineFilter when lines are filtered. The
+// initial tail is never filtered, so the last lines stay the last lines;
+// the filters pick what to watch for from then on.
+func sink(name string) io.Writer {
+	w := out.from(name)
+	if filtering() {
+		return &lineFilter{w: w}
+	}
+	return w
+}
+
+// lineFilter passes on the complete lines written to it that --match and
+// --exclude let through, highlighted with --highlight. A partial line is
+// held back until the rest of it is written, as followed files often grow
+// by pieces of lines.
+type lineFilter struct {
+	w       io.Writer
+	partial []byte
+}
+
+func (f *lineFilter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, delim)
+		if i < 0 {
+			f.partial = append(f.partial, p...)
+			break
+		}
+
+		line := p[:i+1]
+		if len(f.partial) > 0 {
+			f.partial = append(f.partial, line...)
+			line = f.partial
+		}
+		if err := f.emit(line); err != nil {
+			return n - len(p), err
+		}
+		f.partial = f.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush passes on a last line that will not be completed, like the end of
+// a file that was replaced.
+func (f *lineFilter) flush() error {
+	if len(f.partial) == 0 {
+		return nil
+	}
+	err := f.emit(f.partial)
+	f.partial = f.partial[:0]
+	return err
+}
+
+func (f *lineFilter) emit(line []byte) error {
+	text := bytes.TrimSuffix(line, []byte{delim})
+	if matchRE != nil && !matchRE.Match(text) {
+		return nil
+	}
+	if excludeRE != nil && excludeRE.Match(text) {
+		return nil
+	}
+	if highlightRE != nil && colorize {
+		line = append(highlight(text), line[len(text):]...)
+	}
+	_, err := f.w.Write(line)
+	return err
+}
+
+// highlight wraps every non-empty match of highlightRE in text in colour
+// escapes.
+func highlight(text []byte) []byte {
+	var b []byte
+	last := 0
+	for _, m := range highlightRE.FindAllIndex(text, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		b = append(b, text[last:m[0]]...)
+		b = append(b, highlightStart...)
+		b = append(b, text[m[0]:m[1]]...)
+		b = append(b, highlightEnd...)
+		last = m[1]
+	}
+	return append(b, text[last:]...)
+}
+
+// flushSink passes on what w holds back, if it is a lineFilter.
+func flushSink(w io.Writer) error {
+	if f, ok := w.(*lineFilter); ok {
+		return f.flush()
+	}
+	return nil
+}
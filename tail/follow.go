@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// followMode is the value of --follow[=HOW]. A bare --follow, like -f,
+// follows the descriptor.
+type followMode string
+
+func (m *followMode) IsBoolFlag() bool {
+	return true
+}
+
+func (m *followMode) String() string {
+	if m == nil {
+		return ""
+	}
+	return string(*m)
+}
+
+func (m *followMode) Set(s string) error {
+	switch s {
+	case "true", "descriptor":
+		*m = "descriptor"
+	case "name":
+		*m = "name"
+	case "false":
+		*m = ""
+	default:
+		return fmt.Errorf("invalid argument '%s' for '--follow'", s)
+	}
+	return nil
+}
+
+var followHow followMode
+
+var (
+	// sleepInterval is how often files are polled, and how often a
+	// followed name is looked up again when watching, which catches what
+	// fsnotify can't see, like a directory that is yet to exist
+	sleepInterval = time.Second
+
+	disableInotify    bool
+	maxUnchangedStats = 5
+)
+
+// followedFile is a file being followed. In name mode file is nil while
+// the name is inaccessible.
+type followedFile struct {
+	name     string
+	file     *os.File
+	dev, ino uint64
+	w        io.Writer // where its data goes, see sink
+
+	// synthetic files, like those in /proc, report no useful size or
+	// mtime, so they are read on every poll and never seen truncated
+	synthetic bool
+
+	// what the last poll saw, when polling
+	size      int64
+	mtime     time.Time
+	unchanged int
+}
+
+func fileID(info os.FileInfo) (dev, ino uint64) {
+	st := info.Sys().(*syscall.Stat_t)
+	return uint64(st.Dev), st.Ino
+}
+
+// path is where the file is found; standard input is reached through
+// /dev/stdin, which fsnotify resolves to the file behind it.
+func (f *followedFile) path() string {
+	if f.name == "-" {
+		return "/dev/stdin"
+	}
+	return f.name
+}
+
+// open opens the name, positioned at its end unless fromStart is set.
+func (f *followedFile) open(fromStart bool) error {
+	file, err := openInput(f.name)
+	if err != nil {
+		return err
+	}
+	if !fromStart {
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := f.adopt(file); err != nil {
+		file.Close()
+		return err
+	}
+	return nil
+}
+
+// adopt makes file, already open and positioned, the one followed.
+func (f *followedFile) adopt(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.dev, f.ino = fileID(info)
+	f.synthetic = syntheticFS(file)
+	return nil
+}
+
+func (f *followedFile) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// readNew copies what was appended since the last read, starting over when
+// the file was truncated.
+func (f *followedFile) readNew() error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	pos, err := f.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if info.Size() < pos && !f.synthetic {
+		warn("%s: file truncated", displayName(f.name))
+		flushSink(f.w)
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(f.w, f.file)
+	return err
+}
+
+// checkName notices when the name has come to refer to another file, as
+// after log rotation, or to none at all. It returns false once the name is
+// given up on.
+func (f *followedFile) checkName() bool {
+	info, err := os.Stat(f.name)
+	if err != nil {
+		if f.file != nil {
+			// whatever was written before the rename is still ours
+			f.readNew()
+			flushSink(f.w)
+			f.close()
+			warn("'%s' has become inaccessible: %s", f.name, errorText(err))
+			if !retry || matchesPattern(f.name) {
+				warn("%s: giving up on this name", f.name)
+				return false
+			}
+		}
+		return true
+	}
+
+	dev, ino := fileID(info)
+	if f.file != nil && dev == f.dev && ino == f.ino {
+		return true
+	}
+
+	if f.file != nil {
+		f.readNew()
+		flushSink(f.w)
+		f.close()
+		warn("'%s' has been replaced;  following new file", f.name)
+	} else {
+		warn("'%s' has appeared;  following new file", f.name)
+	}
+	if err := f.open(true); err != nil {
+		warn("cannot open '%s' for reading: %s", f.name, errorText(err))
+		return true
+	}
+	if err := f.readNew(); err != nil {
+		fail("error reading '%s': %s", f.name, errorText(err))
+	}
+	return true
+}
+
+// watchPath is what the watcher observes for f: the file itself when
+// following a descriptor, its directory when following a name, so renames,
+// removals and new files under the name are all seen.
+func (f *followedFile) watchPath() string {
+	if followHow == "name" {
+		return filepath.Dir(f.name)
+	}
+	return f.path()
+}
+
+// follower tracks the files still followed, whichever backend feeds it.
+type follower struct {
+	active []*followedFile
+
+	// closed once the processes given with --pid have all exited; nil,
+	// and so never ready, without --pid
+	pidsGone <-chan struct{}
+}
+
+func newFollower(files []*followedFile) *follower {
+	fl := &follower{active: files}
+	if len(pids) > 0 {
+		fl.pidsGone = watchPids(pids)
+	}
+	return fl
+}
+
+// update runs fn on every active file, dropping those it gives up on. It
+// returns false when no file is left.
+func (fl *follower) update(fn func(f *followedFile) bool) bool {
+	remaining := fl.active[:0]
+	for _, f := range fl.active {
+		if fn(f) {
+			remaining = append(remaining, f)
+		} else {
+			f.close()
+		}
+	}
+	fl.active = remaining
+	out.flush()
+	if len(fl.active) == 0 && len(patterns) == 0 {
+		fail("no files remaining")
+		return false
+	}
+	return true
+}
+
+// finish passes on what the --pid processes wrote before they exited.
+func (fl *follower) finish() {
+	for _, f := range fl.active {
+		if f.file != nil {
+			f.read()
+		}
+		flushSink(f.w)
+	}
+	out.flush()
+}
+
+func (fl *follower) close() {
+	for _, f := range fl.active {
+		f.close()
+	}
+}
+
+// read is readNew reporting errors, false meaning the file is given up on.
+func (f *followedFile) read() bool {
+	if err := f.readNew(); err != nil {
+		fail("error reading '%s': %s", displayName(f.name), errorText(err))
+		return false
+	}
+	return true
+}
+
+// retryOpen tries again to open a file that could not be opened at first
+// when following a descriptor, reading it from its start once it appears.
+func (f *followedFile) retryOpen() bool {
+	if f.open(true) != nil {
+		return true
+	}
+	warn("'%s' has appeared;  following new file", f.name)
+	return f.read()
+}
+
+// followFiles outputs data appended to any of files until none is left to
+// follow. Filesystems that don't report changes, or a watcher that can't
+// be set up, make it poll instead.
+func followFiles(files []*followedFile) error {
+	fl := newFollower(files)
+	defer fl.close()
+	if !fl.update(func(*followedFile) bool { return true }) {
+		return nil
+	}
+
+	if !disableInotify && !anyRemote(files) {
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = watchFiles(fl, watcher)
+			if err != errCannotWatch {
+				return err
+			}
+		}
+		warn("inotify cannot be used, reverting to polling")
+	}
+	return pollFiles(fl)
+}
+
+func anyRemote(files []*followedFile) bool {
+	for _, f := range files {
+		if remoteFS(f.path()) {
+			return true
+		}
+	}
+	return false
+}
+
+var errCannotWatch = errors.New("cannot watch")
+
+// watchFiles reads all the files from one goroutine fed by a single
+// watcher. It returns errCannotWatch when something cannot be added to the
+// watcher, leaving the files where they were read up to for polling.
+func watchFiles(fl *follower, watcher *fsnotify.Watcher) error {
+	defer watcher.Close()
+	byName := followHow == "name"
+
+	// watched records the paths added to the watcher; a directory is
+	// shared by all the names in it. Following names, a directory yet to
+	// exist is tried again later, but any other failure, like running out
+	// of watches, would leave changes unseen.
+	watched := make(map[string]bool)
+	watch := func(path string) error {
+		path = filepath.Clean(path)
+		if watched[path] {
+			return nil
+		}
+		err := watcher.Add(path)
+		watched[path] = err == nil
+		if err != nil && (!byName || !os.IsNotExist(err)) {
+			return errCannotWatch
+		}
+		return nil
+	}
+	watchAll := func() error {
+		for _, f := range fl.active {
+			if f.file != nil || byName {
+				if err := watch(f.watchPath()); err != nil {
+					return err
+				}
+			}
+		}
+		// new files matching a pattern show up in its directory
+		for _, p := range patterns {
+			if err := watch(p.dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// files not open yet are being retried; they are picked up from
+	// their start when they appear
+	if err := watchAll(); err != nil {
+		return err
+	}
+
+	// a zero --sleep-interval polls without pause, but a ticker needs
+	// some period
+	period := sleepInterval
+	if period <= 0 {
+		period = time.Millisecond
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			name := filepath.Clean(event.Name)
+			more := fl.update(func(f *followedFile) bool {
+				if filepath.Clean(f.path()) != name {
+					return true
+				}
+				if byName && !f.checkName() {
+					return false
+				}
+				if event.Has(fsnotify.Write) && f.file != nil {
+					return f.read()
+				}
+				return true
+			})
+			if !more {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				fl.discover()
+			}
+		case <-ticker.C:
+			more := fl.update(func(f *followedFile) bool {
+				switch {
+				case byName:
+					return f.checkName()
+				case f.file == nil:
+					return f.retryOpen()
+				}
+				return true
+			})
+			if !more {
+				return nil
+			}
+			fl.discover()
+			if err := watchAll(); err != nil {
+				return err
+			}
+		case <-fl.pidsGone:
+			fl.finish()
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var remoteTypes = map[string]bool{
+	"nfs": true, "smbfs": true, "afpfs": true, "webdav": true,
+	"cifs": true, "macfuse": true, "osxfuse": true, "fusefs": true,
+}
+
+// remoteFS reports whether path is on a network or user space filesystem,
+// where kqueue may not see changes.
+func remoteFS(path string) bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false
+	}
+	return remoteTypes[unix.ByteSliceToString(st.Fstypename[:])]
+}
+
+// syntheticFS reports whether file is generated when read, like the procfs
+// files darwin does not have.
+func syntheticFS(file *os.File) bool {
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// remoteMagics are the statfs(2) types of filesystems that inotify cannot
+// report changes on: network filesystems, where writes happen on another
+// host, FUSE, and synthetic ones like procfs.
+var remoteMagics = map[int64]bool{
+	0x6969:     true, // nfs
+	0x517b:     true, // smb
+	0xff534d42: true, // cifs
+	0xfe534d42: true, // smb2
+	0x65735546: true, // fuse
+	0x73757245: true, // coda
+	0x5346414f: true, // afs
+	0x6b414653: true, // kafs
+	0x01021997: true, // v9fs
+	0x00c36400: true, // ceph
+	0x01161970: true, // gfs2
+	0x7461636f: true, // ocfs2
+	0x0bd00bd0: true, // lustre
+	0x47504653: true, // gpfs
+	0x013111a8: true, // ibrix
+	0x9fa0:     true, // proc
+	0x62656572: true, // sysfs
+}
+
+// remoteFS reports whether path is on a filesystem inotify cannot watch.
+func remoteFS(path string) bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false
+	}
+	return remoteMagics[int64(st.Type)&0xffffffff]
+}
+
+// syntheticMagics are the statfs(2) types of filesystems whose files are
+// generated when read, so their size and mtime say nothing about content.
+var syntheticMagics = map[int64]bool{
+	0x9fa0:     true, // proc
+	0x62656572: true, // sysfs
+	0x64626720: true, // debugfs
+	0x74726163: true, // tracefs
+}
+
+// syntheticFS reports whether file is on such a filesystem.
+func syntheticFS(file *os.File) bool {
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(file.Fd()), &st); err != nil {
+		return false
+	}
+	return syntheticMagics[int64(st.Type)&0xffffffff]
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// headers is set when output is preceded by "==> name <==" lines: with
+// several files, or -v.
+var headers bool
+
+// output serializes everything written to stdout and, when headers are on,
+// announces which file the data that follows comes from.
+type output struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	current string // file the last data came from
+	started bool   // a header has been printed
+}
+
+var out = &output{w: bufio.NewWriter(os.Stdout)}
+
+// header announces name, as done for each file in turn before its tail.
+func (o *output) header(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.printHeader(name)
+}
+
+func (o *output) printHeader(name string) {
+	o.current = name
+	if !headers {
+		return
+	}
+	if o.started {
+		o.w.WriteString("\n")
+	}
+	fmt.Fprintf(o.w, "==> %s <==\n", name)
+	o.started = true
+}
+
+func (o *output) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Flush()
+}
+
+// from returns a writer for data read from name, which repeats the header
+// only when another file was written in between.
+func (o *output) from(name string) io.Writer {
+	return sourceWriter{o, name}
+}
+
+type sourceWriter struct {
+	o    *output
+	name string
+}
+
+func (s sourceWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	s.o.mu.Lock()
+	defer s.o.mu.Unlock()
+	if s.o.current != s.name {
+		s.o.printHeader(s.name)
+	}
+	n, err := s.o.w.Write(p)
+	// followed data is passed on as soon as it arrives
+	if err == nil && follow {
+		err = s.o.w.Flush()
+	}
+	return n, err
+}
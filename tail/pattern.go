@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// filePattern is an operand of --follow=name that is a directory or a glob
+// rather than a file. The files it matches are followed as they are
+// created, and dropped once removed.
+type filePattern struct {
+	dir  string // watched for new files
+	glob string // matched against the names in dir
+}
+
+var patterns []*filePattern
+
+// parsePattern returns the pattern arg stands for, or nil for a plain name.
+// Only the last element of a glob may hold wildcards, as that directory is
+// the one watched.
+func parsePattern(arg string) *filePattern {
+	if arg == "-" {
+		return nil
+	}
+	if info, err := os.Stat(arg); err == nil && info.IsDir() {
+		return &filePattern{dir: arg, glob: "*"}
+	}
+	if !strings.ContainsAny(arg, `*?[\`) {
+		return nil
+	}
+	return &filePattern{dir: filepath.Dir(arg), glob: filepath.Base(arg)}
+}
+
+// matches lists the regular files matching p, sorted by name.
+func (p *filePattern) matches() []string {
+	names, _ := filepath.Glob(filepath.Join(p.dir, p.glob))
+	files := names[:0]
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// matchesPattern reports whether name was found through a pattern, and so
+// is dropped rather than retried once removed.
+func matchesPattern(name string) bool {
+	for _, p := range patterns {
+		if filepath.Clean(filepath.Dir(name)) != filepath.Clean(p.dir) {
+			continue
+		}
+		if ok, _ := filepath.Match(p.glob, filepath.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// discover starts following the files matching the patterns that are not
+// followed yet, from their start as they are new.
+func (fl *follower) discover() {
+	if len(patterns) == 0 {
+		return
+	}
+	known := make(map[string]bool)
+	for _, f := range fl.active {
+		known[filepath.Clean(f.name)] = true
+	}
+	for _, p := range patterns {
+		for _, name := range p.matches() {
+			if known[filepath.Clean(name)] {
+				continue
+			}
+			known[filepath.Clean(name)] = true
+
+			f := &followedFile{name: name, w: sink(displayName(name))}
+			if err := f.open(true); err != nil {
+				continue
+			}
+			// like the rotated logs compressed next to the live one
+			if compression(f.file) != "" {
+				f.close()
+				continue
+			}
+			if f.read() {
+				fl.active = append(fl.active, f)
+			} else {
+				f.close()
+			}
+		}
+	}
+	out.flush()
+}
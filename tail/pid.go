@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pidList collects the values of the repeatable --pid flag.
+type pidList []int
+
+func (p *pidList) String() string {
+	if p == nil {
+		return ""
+	}
+	pids := make([]string, len(*p))
+	for i, pid := range *p {
+		pids[i] = strconv.Itoa(pid)
+	}
+	return strings.Join(pids, ",")
+}
+
+func (p *pidList) Set(s string) error {
+	pid, err := strconv.Atoi(s)
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid PID: '%s'", s)
+	}
+	*p = append(*p, pid)
+	return nil
+}
+
+var pids pidList
+
+// watchPids returns a channel that is closed once every process in pids
+// has exited.
+func watchPids(pids []int) <-chan struct{} {
+	gone := make(chan struct{})
+	go func() {
+		waitPids(pids)
+		close(gone)
+	}()
+	return gone
+}
+
+// alive drops the processes that no longer exist, the fallback check for
+// processes that cannot be waited on directly. EPERM still means the
+// process is there.
+func alive(pids []int) []int {
+	remaining := pids[:0]
+	for _, pid := range pids {
+		if unix.Kill(pid, 0) != unix.ESRCH {
+			remaining = append(remaining, pid)
+		}
+	}
+	return remaining
+}
+
+// pollPids checks on pids every sleepInterval until they are all gone.
+func pollPids(pids []int) {
+	for pids = alive(pids); len(pids) > 0; pids = alive(pids) {
+		time.Sleep(sleepInterval)
+	}
+}
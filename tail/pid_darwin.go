@@ -0,0 +1,5 @@
+package main
+
+func waitPids(pids []int) {
+	pollPids(pids)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitPids blocks until all of pids have exited. A pidfd becomes readable
+// when its process exits, so those are polled; processes pidfd_open(2)
+// refuses, as on kernels before 5.3, are checked with kill(pid, 0) at
+// every sleepInterval instead.
+func waitPids(pids []int) {
+	var fds []unix.PollFd
+	var others []int
+	for _, pid := range pids {
+		fd, err := unix.PidfdOpen(pid, 0)
+		switch {
+		case err == nil:
+			fds = append(fds, unix.PollFd{Fd: int32(fd), Events: unix.POLLIN})
+		case err != unix.ESRCH:
+			others = append(others, pid)
+		}
+	}
+	if len(fds) == 0 {
+		pollPids(others)
+		return
+	}
+
+	for len(fds) > 0 || len(others) > 0 {
+		timeout := -1
+		if len(others) > 0 {
+			timeout = int(sleepInterval / time.Millisecond)
+		}
+		if len(fds) == 0 {
+			time.Sleep(sleepInterval)
+		} else if _, err := unix.Poll(fds, timeout); err != nil && err != unix.EINTR {
+			// without a working poll, fall back to signals for all
+			for _, fd := range fds {
+				unix.Close(int(fd.Fd))
+			}
+			pollPids(append([]int(nil), pids...))
+			return
+		}
+
+		open := fds[:0]
+		for _, fd := range fds {
+			if fd.Revents != 0 {
+				unix.Close(int(fd.Fd))
+			} else {
+				open = append(open, fd)
+			}
+		}
+		fds = open
+		others = alive(others)
+	}
+}
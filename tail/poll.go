@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+)
+
+// pollFiles follows the files of fl by checking on them every
+// sleepInterval, for when changes cannot be watched for.
+func pollFiles(fl *follower) error {
+	for {
+		select {
+		case <-fl.pidsGone:
+			fl.finish()
+			return nil
+		case <-time.After(sleepInterval):
+		}
+		if !fl.update((*followedFile).poll) {
+			return nil
+		}
+		fl.discover()
+	}
+}
+
+// poll reads f if it changed since the last poll. Following a name, after
+// maxUnchangedStats polls without a change, the name is checked for having
+// been replaced, as a rotated log stops changing.
+func (f *followedFile) poll() bool {
+	if f.file == nil {
+		if followHow == "name" {
+			return f.checkName()
+		}
+		return f.retryOpen()
+	}
+
+	if f.synthetic {
+		return f.read()
+	}
+
+	info, err := f.file.Stat()
+	if err != nil {
+		fail("cannot fstat '%s': %s", displayName(f.name), errorText(err))
+		return false
+	}
+	if info.Size() != f.size || !info.ModTime().Equal(f.mtime) {
+		f.size, f.mtime = info.Size(), info.ModTime()
+		f.unchanged = 0
+		return f.read()
+	}
+
+	if followHow == "name" {
+		f.unchanged++
+		if f.unchanged >= maxUnchangedStats {
+			f.unchanged = 0
+			return f.checkName()
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// byteRing keeps the last size bytes written to it. Its buffer grows with
+// the input up to size, so a large count costs nothing on short input.
+type byteRing struct {
+	buf   []byte
+	size  int64
+	start int // index of the oldest byte once the buffer is full
+}
+
+func (r *byteRing) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := r.size - int64(len(r.buf)); room > 0 {
+		take := int64(len(p))
+		if take > room {
+			take = room
+		}
+		r.buf = append(r.buf, p[:take]...)
+		p = p[take:]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	if len(p) >= len(r.buf) {
+		copy(r.buf, p[len(p)-len(r.buf):])
+		r.start = 0
+		return n, nil
+	}
+	copied := copy(r.buf[r.start:], p)
+	copy(r.buf, p[copied:])
+	r.start = (r.start + len(p)) % len(r.buf)
+	return n, nil
+}
+
+func (r *byteRing) WriteTo(w io.Writer) (int64, error) {
+	n1, err := w.Write(r.buf[r.start:])
+	if err != nil {
+		return int64(n1), err
+	}
+	n2, err := w.Write(r.buf[:r.start])
+	return int64(n1 + n2), err
+}
+
+// lineRing keeps the last size lines read, reusing the memory of the lines
+// it drops.
+type lineRing struct {
+	lines [][]byte
+	size  int64
+	next  int // slot the next line goes to once all slots are used
+}
+
+// readFrom reads r to the end, line by line. A final line without a
+// delimiter is kept like any other.
+func (r *lineRing) readFrom(rd io.Reader) error {
+	br := bufio.NewReader(rd)
+	for {
+		chunk, err := br.ReadSlice(delim)
+		if len(chunk) > 0 && r.size > 0 {
+			slot := r.slot()
+			*slot = append(*slot, chunk...)
+			// a line longer than the reader's buffer comes in pieces
+			for err == bufio.ErrBufferFull {
+				chunk, err = br.ReadSlice(delim)
+				*slot = append(*slot, chunk...)
+			}
+		}
+		for err == bufio.ErrBufferFull {
+			_, err = br.ReadSlice(delim)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// slot returns an emptied slot for a new line, dropping the oldest one when
+// the ring is full.
+func (r *lineRing) slot() *[]byte {
+	if int64(len(r.lines)) < r.size {
+		r.lines = append(r.lines, nil)
+		return &r.lines[len(r.lines)-1]
+	}
+	slot := &r.lines[r.next]
+	*slot = (*slot)[:0]
+	r.next = (r.next + 1) % len(r.lines)
+	return slot
+}
+
+func (r *lineRing) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for i := range r.lines {
+		n, err := w.Write(r.lines[(r.next+i)%len(r.lines)])
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -2,193 +2,511 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/fsnotify/fsnotify"
 	"io"
 	"os"
-	"sync"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	numLines int
-	follow   bool
-	verbose  bool
-	retry 	 bool
-
-	printedHeader map[string]bool = make(map[string]bool)
+	linesFlag string
+	bytesFlag string
+	follow    bool
+	verbose   bool
+	quiet     bool
+	retry     bool
 )
 
-func printLastNLines(filename string, n int) error {
+// count is a parsed -n or -c value: the last n lines or bytes, or with a
+// leading '+' everything from line or byte n on.
+type count struct {
+	n         int64
+	fromStart bool
+	bytes     bool
+}
 
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+var numLines count
 
-	stat, err := file.Stat()
-	if err != nil {
-		return err
-	}
+// delim ends lines: a newline, or NUL with -z.
+var delim byte = '\n'
 
-	bufSize := stat.Size()
-	if bufSize > 1e6 { // limit buffer size to 1MB
-		bufSize = 1e6
+var sizeSuffixes = map[string]int64{
+	"b":  512,
+	"kB": 1000, "K": 1 << 10, "KiB": 1 << 10,
+	"MB": 1e6, "M": 1 << 20, "MiB": 1 << 20,
+	"GB": 1e9, "G": 1 << 30, "GiB": 1 << 30,
+	"TB": 1e12, "T": 1 << 40, "TiB": 1 << 40,
+	"PB": 1e15, "P": 1 << 50, "PiB": 1 << 50,
+	"EB": 1e18, "E": 1 << 60, "EiB": 1 << 60,
+}
+
+// parseCount parses N, +N or -N with an optional size suffix like 10K.
+func parseCount(s string, bytes bool) (count, error) {
+	c := count{bytes: bytes}
+	what := "lines"
+	if bytes {
+		what = "bytes"
 	}
 
-	buf := make([]byte, bufSize)
-	startPos := stat.Size()
-	lineCount := 0
-	var positions []int64
+	digits := s
+	if strings.HasPrefix(digits, "+") {
+		c.fromStart = true
+		digits = digits[1:]
+	} else {
+		digits = strings.TrimPrefix(digits, "-")
+	}
 
-	for {
-		length, err := file.ReadAt(buf, startPos-int64(len(buf)))
-		if err != nil && err != io.EOF {
-			return err
+	end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(digits)
+	}
+	n, err := strconv.ParseInt(digits[:end], 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("invalid number of %s: '%s'", what, s)
+	}
+	if suffix := digits[end:]; suffix != "" {
+		unit, ok := sizeSuffixes[suffix]
+		if !ok || n > (1<<63-1)/unit {
+			return c, fmt.Errorf("invalid number of %s: '%s'", what, s)
 		}
+		n *= unit
+	}
+	c.n = n
+	return c, nil
+}
 
-		for i := length - 1; i >= 0; i-- {
-			if buf[i] == '\n' {
-				lineCount++
-				if lineCount > n {
-					positions = append(positions, startPos-int64(length)+int64(i+1))
-					break
-				}
-			}
-		}
+// obsoleteOption matches the historical forms like -20, +5, -5c and -20f.
+var obsoleteOption = regexp.MustCompile(`^([-+])([0-9]*)([bcl]?)(f?)$`)
 
-		if lineCount > n || startPos-int64(length) <= 0 {
-			break
-		}
+// legacyArgs rewrites an obsolete leading count option into -n/-c so the
+// flag package can parse it.
+func legacyArgs(args []string) []string {
+	if len(args) < 2 {
+		return args
+	}
+	m := obsoleteOption.FindStringSubmatch(args[1])
+	// without digits, "-f" or "-c" are ordinary options
+	if m == nil || m[1] == "-" && m[2] == "" {
+		return args
+	}
 
-		startPos -= int64(length)
+	sign, digits, unit := m[1], m[2], m[3]
+	if digits == "" {
+		digits = "10"
+	}
+	if unit == "b" {
+		n, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			return args
+		}
+		digits = strconv.FormatInt(n*512, 10)
 	}
 
-	if len(positions) == 0 {
-		return nil
+	opt := "-n"
+	if unit == "b" || unit == "c" {
+		opt = "-c"
+	}
+	rewritten := []string{args[0], opt, sign + digits}
+	if m[4] != "" {
+		rewritten = append(rewritten, "-f")
 	}
+	return append(rewritten, args[2:]...)
+}
+
+// chunkSize is how much of a file is read at a time when looking for the
+// start of its last lines.
+var chunkSize = 64 << 10
 
-	_, err = file.Seek(positions[len(positions)-1], 0)
+// printLastNLines copies the last n lines of file, found by reading it
+// backwards.
+func printLastNLines(w io.Writer, file *os.File, n int64) error {
+	stat, err := file.Stat()
 	if err != nil {
 		return err
 	}
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		fmt.Println(scanner.Text())
+	start, err := lastLinesStart(file, stat.Size(), n)
+	if err != nil {
+		return err
 	}
-
-	return scanner.Err()
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
 }
 
+// lastLinesStart returns the offset where the last n lines of the first
+// size bytes of r start. A delimiter at the very end closes the last line
+// rather than starting an empty one, and a final line without one still
+// counts.
+func lastLinesStart(r io.ReaderAt, size, n int64) (int64, error) {
+	if n == 0 {
+		return size, nil
+	}
 
-func followFile(filename string, wg *sync.WaitGroup) error {
-	defer wg.Done()
+	buf := make([]byte, chunkSize)
+	for pos := size; pos > 0; {
+		chunk := int64(len(buf))
+		if chunk > pos {
+			chunk = pos
+		}
+		pos -= chunk
 
-	if verbose {
-		fmt.Printf("==> %s <==\n", filename)
+		b := buf[:chunk]
+		if read, err := r.ReadAt(b, pos); err != nil && !(err == io.EOF && read == len(b)) {
+			return 0, err
+		}
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] != delim || pos+int64(i) == size-1 {
+				continue
+			}
+			if n--; n == 0 {
+				return pos + int64(i) + 1, nil
+			}
+		}
 	}
+	return 0, nil
+}
 
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
+// openInput opens a file operand, "-" standing for standard input.
+func openInput(filename string) (*os.File, error) {
+	if filename == "-" {
+		return os.Stdin, nil
 	}
-	defer file.Close()
+	return os.Open(filename)
+}
+
+// printTail writes the part of file selected by numLines to w under its
+// header, leaving the file positioned where the output ended. Regular files
+// are read from where the output starts; anything else, compressed and
+// zero size files included, is read through.
+func printTail(w io.Writer, file *os.File, filename string) error {
+	out.header(displayName(filename))
 
-	// Start from the end of the file
-	pos, err := file.Seek(0, io.SeekEnd)
+	stat, err := file.Stat()
 	if err != nil {
 		return err
 	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
+	// files like those in /proc claim to be empty yet have content, so
+	// their size cannot be relied on
+	if !stat.Mode().IsRegular() || stat.Size() == 0 {
+		return printStreamTail(w, file)
+	}
+	if format := compression(file); format != "" {
+		r, err := decompress(file, format)
+		if err != nil {
+			return err
+		}
+		return printStreamTail(w, r)
 	}
-	defer watcher.Close()
 
-	err = watcher.Add(filename)
-	if err != nil {
+	switch {
+	case numLines.fromStart && numLines.bytes:
+		// not past the end, which following would take for truncation
+		offset := numLines.n - 1
+		if offset > stat.Size() {
+			offset = stat.Size()
+		}
+		if offset > 0 {
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		_, err = io.Copy(w, file)
+		return err
+	case numLines.fromStart:
+		return printFrom(w, file)
+	case numLines.bytes:
+		if offset := stat.Size() - numLines.n; offset > 0 {
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		_, err = io.Copy(w, file)
 		return err
 	}
+	return printLastNLines(w, file, numLines.n)
+}
 
-	for {
-		select {
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return nil
-			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				newPos, err := file.Seek(0, io.SeekEnd)
-				if err != nil {
-					return err
-				}
-				if newPos < pos {
-					// The file got smaller, so it must have been truncated
-					pos = newPos
-				}
-				if newPos > pos {
-					// The file grew, so fucking read it
-					readBuf := make([]byte, newPos-pos)
-					_, err = file.ReadAt(readBuf, pos)
-					if err != nil {
-						return err
-					}
-					fmt.Print(string(readBuf))
-					pos = newPos
+// printFrom skips to line or byte numLines.n, counting from 1, and copies
+// the rest of r. Regular files seek to a byte instead.
+func printFrom(w io.Writer, r io.Reader) error {
+	skip := numLines.n - 1
+	if numLines.bytes {
+		if skip > 0 {
+			if _, err := io.CopyN(io.Discard, r, skip); err != nil {
+				if err == io.EOF {
+					return nil
 				}
+				return err
 			}
-		case err, ok := <-watcher.Errors:
-			if !ok {
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	for ; skip > 0; skip-- {
+		// ReadSlice stops at full buffers too, so long lines are skipped
+		// in pieces
+		for {
+			_, err := br.ReadSlice(delim)
+			if err == nil {
+				break
+			}
+			if err == io.EOF {
 				return nil
 			}
+			if err != bufio.ErrBufferFull {
+				return err
+			}
+		}
+	}
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// printStreamTail handles inputs that cannot be seeked, like pipes. Only the
+// last lines or bytes are kept in memory while reading to the end.
+func printStreamTail(w io.Writer, r io.Reader) error {
+	if numLines.fromStart {
+		return printFrom(w, r)
+	}
+
+	if numLines.bytes {
+		ring := &byteRing{size: numLines.n}
+		if _, err := io.Copy(ring, r); err != nil {
 			return err
 		}
+		_, err := ring.WriteTo(w)
+		return err
+	}
+
+	ring := &lineRing{size: numLines.n}
+	if err := ring.readFrom(r); err != nil {
+		return err
 	}
+	_, err := ring.WriteTo(w)
+	return err
 }
 
+// displayName is how a file operand appears in headers and messages.
+func displayName(filename string) string {
+	if filename == "-" {
+		return "standard input"
+	}
+	return filename
+}
+
+// isPipe reports whether file is a pipe, FIFO or socket, which following
+// could only read to the end.
+func isPipe(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&(os.ModeNamedPipe|os.ModeSocket) != 0
+}
+
+// exitStatus becomes 1 once any file could not be read.
+var exitStatus int
+
+// warn reports a problem that does not change the exit status, like a
+// followed file being replaced.
+func warn(format string, a ...interface{}) {
+	out.flush()
+	fmt.Fprintf(os.Stderr, "tail: "+format+"\n", a...)
+}
+
+func fail(format string, a ...interface{}) {
+	warn(format, a...)
+	exitStatus = 1
+}
+
+// errorText returns the bare system error message of err, capitalized the
+// way strerror(3) reports it.
+func errorText(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		msg := errno.Error()
+		return strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	return err.Error()
+}
 
 func main() {
-	flag.IntVar(&numLines, "n", 10, "the number of lines to display")
+	os.Args = legacyArgs(os.Args)
+	// GNU spells this option with three dashes, which flag rejects
+	for i, arg := range os.Args {
+		if arg == "--" {
+			break
+		}
+		if arg == "---disable-inotify" {
+			os.Args[i] = "--disable-inotify"
+		}
+	}
+
+	flag.StringVar(&linesFlag, "n", "10", "output the last NUM lines, or use +NUM to output starting with line NUM")
+	flag.StringVar(&bytesFlag, "c", "", "output the last NUM bytes, or use +NUM to output starting with byte NUM")
 	flag.BoolVar(&follow, "f", false, "output appended data as the file grows")
+	flag.Var(&followHow, "follow", "output appended data as the file grows; HOW is 'name' or 'descriptor' (the default)")
+	followName := flag.Bool("F", false, "same as --follow=name --retry")
+	sleepFlag := flag.String("s", "", "with -f, sleep for approximately N seconds (default 1.0) between iterations")
+	flag.StringVar(sleepFlag, "sleep-interval", "", "with -f, sleep for approximately N seconds (default 1.0) between iterations")
+	flag.BoolVar(&disableInotify, "disable-inotify", false, "poll files instead of watching them for changes")
+	unchangedFlag := flag.String("max-unchanged-stats", "", "with --follow=name, reopen a FILE which has not changed size after N (default 5) iterations to see if it has been unlinked or renamed")
+	matchFlag := flag.String("match", "", "with -f, output only appended lines matching REGEX")
+	excludeFlag := flag.String("exclude", "", "with -f, do not output appended lines matching REGEX")
+	highlightFlag := flag.String("highlight", "", "with -f, highlight the parts of appended lines matching REGEX when output is a terminal")
+	flag.Var(&pids, "pid", "with -f, terminate after process ID, PID dies; can be repeated to wait for several processes")
+	zeroFlag := flag.Bool("z", false, "line delimiter is NUL, not newline")
+	flag.BoolVar(zeroFlag, "zero-terminated", false, "line delimiter is NUL, not newline")
 	flag.BoolVar(&verbose, "v", false, "always output headers giving file names")
-	flag.BoolVar(&retry, "R", false, "keep trying to open a file eveb when it's not accessible")
+	flag.BoolVar(&verbose, "verbose", false, "always output headers giving file names")
+	flag.BoolVar(&quiet, "q", false, "never output headers giving file names")
+	flag.BoolVar(&quiet, "quiet", false, "never output headers giving file names")
+	flag.BoolVar(&quiet, "silent", false, "never output headers giving file names")
+	flag.BoolVar(&retry, "R", false, "keep trying to open a file even when it's not accessible")
+	flag.BoolVar(&retry, "retry", false, "keep trying to open a file even when it's not accessible")
 	flag.Parse()
 
-	flag.Parse()
+	if *followName {
+		followHow, retry = "name", true
+	}
+	if follow && followHow == "" {
+		followHow = "descriptor"
+	}
+	follow = followHow != ""
 
-	args := flag.Args()
-	if len(args) < 1 {
-		fmt.Println("Usage: tail [-n numlines] [-f] <file> [<file> ...]")
+	var err error
+	if bytesFlag != "" {
+		numLines, err = parseCount(bytesFlag, true)
+	} else {
+		numLines, err = parseCount(linesFlag, false)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tail: %v\n", err)
 		os.Exit(1)
 	}
+	if *zeroFlag {
+		delim = 0
+	}
+	for _, re := range []struct {
+		pattern string
+		dest    **regexp.Regexp
+	}{{*matchFlag, &matchRE}, {*excludeFlag, &excludeRE}, {*highlightFlag, &highlightRE}} {
+		if re.pattern == "" {
+			continue
+		}
+		if *re.dest, err = regexp.Compile(re.pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "tail: invalid regular expression '%s': %v\n", re.pattern, err)
+			os.Exit(1)
+		}
+	}
+	colorize = isTerminal(os.Stdout)
+	if (matchRE != nil || excludeRE != nil || highlightRE != nil) && !follow {
+		warn("warning: --match, --exclude and --highlight are useful only when following")
+	}
+	if *sleepFlag != "" {
+		seconds, err := strconv.ParseFloat(*sleepFlag, 64)
+		if err != nil || seconds < 0 || seconds > 1e9 {
+			fmt.Fprintf(os.Stderr, "tail: invalid number of seconds: '%s'\n", *sleepFlag)
+			os.Exit(1)
+		}
+		sleepInterval = time.Duration(seconds * float64(time.Second))
+	}
+	if *unchangedFlag != "" {
+		n, err := strconv.Atoi(*unchangedFlag)
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "tail: invalid maximum number of unchanged stats between opens: '%s'\n", *unchangedFlag)
+			os.Exit(1)
+		}
+		maxUnchangedStats = n
+	}
+	if len(pids) > 0 && !follow {
+		warn("warning: PID ignored; --pid=PID is useful only when following")
+	}
+	switch {
+	case retry && !follow:
+		warn("warning: --retry ignored; --retry is useful only when following")
+	case retry && followHow == "descriptor":
+		warn("warning: --retry only effective for the initial open")
+	}
 
-	var wg sync.WaitGroup
-
-	for _, filename := range args {
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
 
-		if verbose && !printedHeader[filename] {
-			fmt.Printf("==> %s <==\n", filename)
-			printedHeader[filename] = true
+	// in name mode, directories and globs stand for the files they hold
+	if followHow == "name" {
+		var names []string
+		seen := make(map[string]bool)
+		for _, arg := range args {
+			expanded := []string{arg}
+			if p := parsePattern(arg); p != nil {
+				patterns = append(patterns, p)
+				expanded = p.matches()
+			}
+			for _, name := range expanded {
+				if !seen[filepath.Clean(name)] {
+					seen[filepath.Clean(name)] = true
+					names = append(names, name)
+				}
+			}
 		}
-		err := printLastNLines(filename, numLines)
+		args = names
+	}
+
+	headers = verbose || (len(args) > 1 || len(patterns) > 0) && !quiet
+
+	var followed []*followedFile
+	for _, filename := range args {
+		file, err := openInput(filename)
 		if err != nil {
-			fmt.Println("Error: ", err)
-			os.Exit(1)
+			fail("cannot open '%s' for reading: %s", displayName(filename), errorText(err))
+			if follow && retry {
+				followed = append(followed, &followedFile{name: filename, w: sink(displayName(filename))})
+			}
+			continue
+		}
+		w := out.from(displayName(filename))
+		if err := printTail(w, file, filename); err != nil {
+			fail("error reading '%s': %s", displayName(filename), errorText(err))
+			file.Close()
+			continue
 		}
 
-		if follow {
-			wg.Add(1)
-			go func(filename string) {
-				err := followFile(filename, &wg)
-				if err != nil {
-					fmt.Println("Error: ", err)
-					os.Exit(1)
-				}
-			}(filename)
+		// a pipe has been read to its end already, a compressed file is
+		// not appended to, and a name cannot be reopened for standard input
+		switch {
+		case !follow || isPipe(file) || compression(file) != "":
+			file.Close()
+		case filename == "-" && followHow == "name":
+			fail("cannot follow '-' by name")
+			file.Close()
+		default:
+			// following picks up exactly where the tail ended
+			f := &followedFile{name: filename, w: sink(displayName(filename))}
+			if err := f.adopt(file); err != nil {
+				fail("%s: %s", displayName(filename), errorText(err))
+				file.Close()
+				continue
+			}
+			followed = append(followed, f)
 		}
 	}
+	out.flush()
 
-	wg.Wait()
+	if follow && (len(followed) > 0 || len(patterns) > 0 || exitStatus != 0) {
+		if err := followFiles(followed); err != nil {
+			fail("%v", err)
+		}
+	}
+	os.Exit(exitStatus)
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseCount(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected count
+	}{
+		{"10", count{n: 10}},
+		{"-5", count{n: 5}},
+		{"+3", count{n: 3, fromStart: true}},
+		{"2K", count{n: 2048}},
+		{"1kB", count{n: 1000}},
+		{"3b", count{n: 1536}},
+		{"+1MiB", count{n: 1 << 20, fromStart: true}},
+	}
+
+	for _, test := range tests {
+		got, err := parseCount(test.arg, false)
+		if err != nil || got != test.expected {
+			t.Errorf("parseCount(%q): expected %+v, got %+v (%v)", test.arg, test.expected, got, err)
+		}
+	}
+
+	for _, bad := range []string{"", "x", "1X", "+", "9999999999E"} {
+		if _, err := parseCount(bad, false); err == nil {
+			t.Errorf("parseCount(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestLegacyArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"tail", "-20", "f"}, []string{"tail", "-n", "-20", "f"}},
+		{[]string{"tail", "+5"}, []string{"tail", "-n", "+5"}},
+		{[]string{"tail", "-3c", "f"}, []string{"tail", "-c", "-3", "f"}},
+		{[]string{"tail", "-2b"}, []string{"tail", "-c", "-1024"}},
+		{[]string{"tail", "-20f", "f"}, []string{"tail", "-n", "-20", "-f", "f"}},
+		{[]string{"tail", "-f", "f"}, []string{"tail", "-f", "f"}},
+		{[]string{"tail", "-n", "3"}, []string{"tail", "-n", "3"}},
+	}
+
+	for _, test := range tests {
+		if got := legacyArgs(test.args); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("legacyArgs(%q): expected %q, got %q", test.args, test.expected, got)
+		}
+	}
+}
+
+// lastLines is the obvious, whole input version of the tail scanners.
+func lastLines(data string, n int) string {
+	lines := strings.SplitAfter(data, string(delim))
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "")
+}
+
+func TestLastLines(t *testing.T) {
+	defer func(size int) { chunkSize = size }(chunkSize)
+
+	inputs := []string{"", "\n", "\n\n\n", "a", "a\n", "a\nb", "a\nb\n", "one\ntwo\n\nfour\nfive", "long line\nx\n"}
+	for _, input := range inputs {
+		for n := 0; n <= 6; n++ {
+			expected := lastLines(input, n)
+
+			// every chunk size puts the chunk boundaries somewhere else
+			for chunkSize = 1; chunkSize <= len(input)+1; chunkSize++ {
+				start, err := lastLinesStart(strings.NewReader(input), int64(len(input)), int64(n))
+				if err != nil || input[start:] != expected {
+					t.Errorf("lastLinesStart(%q, %d) with chunks of %d: expected %q, got %q", input, n, chunkSize, expected, input[start:])
+				}
+			}
+
+			ring := &lineRing{size: int64(n)}
+			var b bytes.Buffer
+			if err := ring.readFrom(strings.NewReader(input)); err != nil {
+				t.Fatal(err)
+			}
+			ring.WriteTo(&b)
+			if b.String() != expected {
+				t.Errorf("lineRing(%q, %d): expected %q, got %q", input, n, expected, b.String())
+			}
+		}
+	}
+}
+
+func TestZeroSizeFile(t *testing.T) {
+	const name = "/proc/self/limits"
+	content, err := os.ReadFile(name)
+	if err != nil || len(content) < 10 {
+		t.Skipf("no %s to test with", name)
+	}
+	defer func(c count) { numLines = c }(numLines)
+
+	tests := []struct {
+		count    count
+		expected string
+	}{
+		{count{n: 2}, lastLines(string(content), 2)},
+		{count{n: 10, bytes: true}, string(content[len(content)-10:])},
+		{count{n: 3, bytes: true, fromStart: true}, string(content[2:])},
+	}
+	for _, test := range tests {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		numLines = test.count
+		var b bytes.Buffer
+		if err := printTail(&b, file, name); err != nil || b.String() != test.expected {
+			t.Errorf("printTail(%s) with %+v: expected %q, got %q (%v)", name, test.count, test.expected, b.String(), err)
+		}
+		file.Close()
+	}
+}
+
+func TestByteRing(t *testing.T) {
+	for size := 0; size <= 8; size++ {
+		ring := &byteRing{size: int64(size)}
+		input := ""
+		for _, piece := range []string{"ab", "", "cdefg", "h", "ijklmnopqrst"} {
+			ring.Write([]byte(piece))
+			input += piece
+
+			var b bytes.Buffer
+			ring.WriteTo(&b)
+			expected := input
+			if len(expected) > size {
+				expected = expected[len(expected)-size:]
+			}
+			if b.String() != expected {
+				t.Errorf("byteRing of %d after %q: expected %q, got %q", size, input, expected, b.String())
+			}
+		}
+	}
+}
+
+func TestLineFilter(t *testing.T) {
+	defer func() { matchRE, excludeRE, highlightRE, colorize = nil, nil, nil, false }()
+	matchRE = regexp.MustCompile("err")
+	excludeRE = regexp.MustCompile("debug")
+	highlightRE = regexp.MustCompile("e?rr")
+	colorize = true
+
+	var b bytes.Buffer
+	f := &lineFilter{w: &b}
+	for _, piece := range []string{"ok\nerr ", "one\nerr debug\n", "no e", "rr\nlast err"} {
+		f.Write([]byte(piece))
+	}
+	f.flush()
+
+	expected := "\x1b[01;31merr\x1b[m one\nno \x1b[01;31merr\x1b[m\nlast \x1b[01;31merr\x1b[m"
+	if b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestMatchesPattern(t *testing.T) {
+	defer func() { patterns = nil }()
+	patterns = []*filePattern{parsePattern("logs/app-*.log"), {dir: "/var/log/svc", glob: "*"}}
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"logs/app-1.log", true},
+		{"./logs/app-2.log", true},
+		{"logs/app-1.log.gz", false},
+		{"logs/sub/app-1.log", false},
+		{"/var/log/svc/current", true},
+		{"/var/log/other", false},
+	}
+	for _, test := range tests {
+		if got := matchesPattern(test.name); got != test.expected {
+			t.Errorf("matchesPattern(%q): expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("one\ntwo\n"))
+	zw.Close()
+
+	dir := t.TempDir()
+	for _, test := range []struct {
+		content, format, expected string
+	}{
+		{gz.String(), "gzip", "one\ntwo\n"},
+		{"BZh", "", ""},
+		{"\x1f\x8bnot gzip\n", "", ""},
+		{"\x1f\x8b", "", ""},
+		{"BZh9\x17\x72\x45\x38\x50\x90\x00\x00\x00\x00", "bzip2", ""},
+		{"BZh9 is not bzip2\n", "", ""},
+		{"plain text\n", "", ""},
+	} {
+		path := filepath.Join(dir, "log")
+		if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		format := compression(file)
+		if format != test.format {
+			t.Errorf("compression(%q): expected %q, got %q", test.content, test.format, format)
+		}
+		if format != "" {
+			r, err := decompress(file, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			if err != nil || string(content) != test.expected {
+				t.Errorf("decompress: expected %q, got %q (%v)", test.expected, content, err)
+			}
+		}
+		file.Close()
+	}
+}